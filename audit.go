@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit log of every received message as a line of JSON
+// e.g.
+// {"time":"2024-05-01T10:00:00.123456Z","source":"127.0.0.1:40000","version":"1","community":"public",
+//  "pdu":"get","requestId":42,"varbinds":[{"oid":".1.3.6.1.2.1.1.5.0","type":"Null"}],
+//  "errorStatus":0,"errorIndex":0,"response":[{"oid":".1.3.6.1.2.1.1.5.0","type":"OctetString","value":"printer1"}],
+//  "durationUs":85}
+
+type AuditLog struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+type AuditVarbind struct {
+	Oid   string      `json:"oid"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type AuditRecord struct {
+	Time        string         `json:"time"`
+	Source      string         `json:"source"`
+	Version     string         `json:"version,omitempty"`
+	Community   string         `json:"community,omitempty"`
+	Pdu         string         `json:"pdu,omitempty"`
+	RequestId   int            `json:"requestId"`
+	Varbinds    []AuditVarbind `json:"varbinds,omitempty"`
+	ErrorStatus int            `json:"errorStatus"`
+	ErrorIndex  int            `json:"errorIndex"`
+	Response    []AuditVarbind `json:"response,omitempty"`
+	Error       string         `json:"error,omitempty"` // why there was no response
+	DurationUs  int64          `json:"durationUs"`
+}
+
+var berTypeNames = map[byte]string{
+	berInteger:     "Integer",
+	berOctetString: "OctetString",
+	berNull:        "Null",
+	berOid:         "ObjectIdentifier",
+	berIpAddress:   "IpAddress",
+	berCounter32:   "Counter32",
+	berGauge32:     "Gauge32",
+	berTimeTicks:   "TimeTicks",
+	berOpaque:      "Opaque",
+	berCounter64:   "Counter64",
+	berNoSuchObj:   "NoSuchObject",
+	berNoSuchInst:  "NoSuchInstance",
+	berEndOfMib:    "EndOfMibView",
+}
+
+func openAuditLog(filename string) (*AuditLog, error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{w: f}, nil
+}
+
+func auditVarbinds(varbinds []SnmpVarbind) []AuditVarbind {
+	var auditVbs []AuditVarbind
+	for _, vb := range varbinds {
+		auditVb := AuditVarbind{Oid: vb.oid, Type: berTypeNames[vb.valueType], Value: vb.value}
+		if auditVb.Type == "" {
+			auditVb.Type = "Unknown"
+		}
+		if bytes, ok := vb.value.([]byte); ok {
+			// show octet strings as text where we can
+			auditVb.Value = string(bytes)
+			if !isPrintable(string(bytes)) {
+				auditVb.Type += "Hex"
+				auditVb.Value = hex.EncodeToString(bytes)
+			}
+		}
+		auditVbs = append(auditVbs, auditVb)
+	}
+	return auditVbs
+}
+
+// log writes the record of one message
+// request is nil if it couldn't be decoded and response is nil if it was dropped
+func (audit *AuditLog) log(start time.Time, duration time.Duration, source net.Addr, request *SnmpPacket,
+	response []byte, err error) {
+
+	record := AuditRecord{
+		Time:       start.UTC().Format(time.RFC3339Nano),
+		Source:     source.String(),
+		DurationUs: duration.Microseconds(),
+	}
+	if request != nil {
+		switch request.version {
+		case snmpVersion1:
+			record.Version = "1"
+		case snmpVersion2c:
+			record.Version = "2c"
+		default:
+			record.Version = strconv.Itoa(request.version) // e.g. 3
+		}
+		record.Community = request.community
+		record.Pdu = pduNames[request.pduType]
+		record.RequestId = request.requestId
+		record.Varbinds = auditVarbinds(request.varbinds)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if response != nil {
+		responsePacket, err := decodeSnmpPacket(response)
+		if err != nil {
+			record.Error = "Invalid response: " + err.Error()
+		} else {
+			record.ErrorStatus = responsePacket.errorStatus
+			record.ErrorIndex = responsePacket.errorIndex
+			record.Response = auditVarbinds(responsePacket.varbinds)
+		}
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.Printf("Failed to encode audit record: %s\n", err)
+		return
+	}
+	audit.lock.Lock()
+	defer audit.lock.Unlock()
+	_, err = audit.w.Write(append(line, '\n'))
+	if err != nil {
+		logger.Printf("Failed to write audit record: %s\n", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+func ExampleAudit1() {
+	var buf bytes.Buffer
+	audit := &AuditLog{w: &buf}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 123456000, time.UTC)
+	source := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+
+	request := &SnmpPacket{version: snmpVersion2c, community: "public", pduType: pduGetRequest, requestId: 42,
+		varbinds: []SnmpVarbind{
+			{".1.3.6.1.2.1.1.5.0", berNull, nil},
+			{".1.3.6.1.2.1.1.3.0", berNull, nil},
+			{".1.3.6.1.2.1.25.3.5.1.2.1", berNull, nil},
+			{".1.3.6.1.2.1.99.1.0", berNull, nil},
+		}}
+	response := *request
+	response.pduType = pduGetResponse
+	response.varbinds = []SnmpVarbind{
+		{".1.3.6.1.2.1.1.5.0", berOctetString, []byte("printer1")},
+		{".1.3.6.1.2.1.1.3.0", berTimeTicks, uint(360000)},
+		{".1.3.6.1.2.1.25.3.5.1.2.1", berOctetString, []byte{0x20, 0x10}},
+		{".1.3.6.1.2.1.99.1.0", berNoSuchObj, nil},
+	}
+	datagram, err := encodeSnmpPacket(&response)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	audit.log(start, 85*time.Microsecond, source, request, datagram, nil)
+
+	request = &SnmpPacket{version: snmpVersion1, community: "secret", pduType: pduGetNextRequest, requestId: 43}
+	audit.log(start, 0, source, request, nil, errors.New(`Authentication failure for community "secret"`))
+	audit.log(start, 0, source, nil, nil, errors.New("Truncated BER item"))
+
+	fmt.Print(buf.String())
+	// Output:
+	// {"time":"2024-05-01T10:00:00.123456Z","source":"127.0.0.1:40000","version":"2c","community":"public","pdu":"get","requestId":42,"varbinds":[{"oid":".1.3.6.1.2.1.1.5.0","type":"Null"},{"oid":".1.3.6.1.2.1.1.3.0","type":"Null"},{"oid":".1.3.6.1.2.1.25.3.5.1.2.1","type":"Null"},{"oid":".1.3.6.1.2.1.99.1.0","type":"Null"}],"errorStatus":0,"errorIndex":0,"response":[{"oid":".1.3.6.1.2.1.1.5.0","type":"OctetString","value":"printer1"},{"oid":".1.3.6.1.2.1.1.3.0","type":"TimeTicks","value":360000},{"oid":".1.3.6.1.2.1.25.3.5.1.2.1","type":"OctetStringHex","value":"2010"},{"oid":".1.3.6.1.2.1.99.1.0","type":"NoSuchObject"}],"durationUs":85}
+	// {"time":"2024-05-01T10:00:00.123456Z","source":"127.0.0.1:40000","version":"1","community":"secret","pdu":"getnext","requestId":43,"errorStatus":0,"errorIndex":0,"error":"Authentication failure for community \"secret\"","durationUs":0}
+	// {"time":"2024-05-01T10:00:00.123456Z","source":"127.0.0.1:40000","requestId":0,"errorStatus":0,"errorIndex":0,"error":"Truncated BER item","durationUs":0}
+}
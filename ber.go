@@ -0,0 +1,459 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Minimal BER decoding and encoding of SNMP v1/v2c messages
+// The agent library hides the packets it processes, so we decode them
+// ourselves for statistics, access control and logging,
+// and encode the few messages we send ourselves (traps and error responses).
+//
+// Message ::= SEQUENCE { version INTEGER, community OCTET STRING, pdu }
+// pdu ::= [tag] { request-id INTEGER, error-status INTEGER, error-index INTEGER,
+//                 variable-bindings SEQUENCE OF SEQUENCE { name OID, value } }
+
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berNull        = 0x05
+	berOid         = 0x06
+	berSequence    = 0x30
+	berIpAddress   = 0x40
+	berCounter32   = 0x41
+	berGauge32     = 0x42
+	berTimeTicks   = 0x43
+	berOpaque      = 0x44
+	berCounter64   = 0x46
+	berNoSuchObj   = 0x80
+	berNoSuchInst  = 0x81
+	berEndOfMib    = 0x82
+)
+
+// PDU types
+const (
+	pduGetRequest     = 0xa0
+	pduGetNextRequest = 0xa1
+	pduGetResponse    = 0xa2
+	pduSetRequest     = 0xa3
+	pduTrapV1         = 0xa4
+	pduGetBulkRequest = 0xa5
+	pduInformRequest  = 0xa6
+	pduTrapV2         = 0xa7
+	pduReport         = 0xa8
+)
+
+// SNMP versions
+const (
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+)
+
+// error-status values
+const (
+	errNoError             = 0
+	errTooBig              = 1
+	errNoSuchName          = 2
+	errBadValue            = 3
+	errReadOnly            = 4
+	errGenErr              = 5
+	errNoAccess            = 6
+	errWrongValue          = 10
+	errResourceUnavailable = 13
+	errAuthorizationError  = 16
+)
+
+var pduNames = map[byte]string{
+	pduGetRequest:     "get",
+	pduGetNextRequest: "getnext",
+	pduGetResponse:    "response",
+	pduSetRequest:     "set",
+	pduTrapV1:         "trap",
+	pduGetBulkRequest: "getbulk",
+	pduInformRequest:  "inform",
+	pduTrapV2:         "trap2",
+	pduReport:         "report",
+}
+
+type SnmpVarbind struct {
+	oid       string
+	valueType byte
+	value     interface{} // int, uint, string, or []byte for octet strings
+}
+
+type SnmpPacket struct {
+	version     int
+	community   string
+	pduType     byte
+	requestId   int
+	errorStatus int // non-repeaters for getbulk
+	errorIndex  int // max-repetitions for getbulk
+	varbinds    []SnmpVarbind
+}
+
+//-------------------------------------------------------------------------------
+// decoding
+
+type berReader struct {
+	data []byte
+	pos  int
+}
+
+// read the next tag, length, value
+func (r *berReader) next() (tag byte, content []byte, err error) {
+	if r.pos+2 > len(r.data) {
+		return 0, nil, errors.New("Truncated BER item")
+	}
+	tag = r.data[r.pos]
+	length := int(r.data[r.pos+1])
+	r.pos += 2
+	if length&0x80 != 0 {
+		// long form: low bits give number of length bytes
+		numBytes := length & 0x7f
+		if numBytes == 0 || numBytes > 4 || r.pos+numBytes > len(r.data) {
+			return 0, nil, errors.New("Invalid BER length")
+		}
+		length = 0
+		for i := 0; i < numBytes; i++ {
+			length = length<<8 | int(r.data[r.pos+i])
+		}
+		r.pos += numBytes
+	}
+	if length < 0 || r.pos+length > len(r.data) {
+		return 0, nil, errors.New("BER length past end of data")
+	}
+	content = r.data[r.pos : r.pos+length]
+	r.pos += length
+	return tag, content, nil
+}
+
+func (r *berReader) expect(expectTag byte) (content []byte, err error) {
+	tag, content, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	if tag != expectTag {
+		return nil, fmt.Errorf("Expecting BER tag 0x%02x but got 0x%02x", expectTag, tag)
+	}
+	return content, nil
+}
+
+func (r *berReader) expectInt() (int, error) {
+	content, err := r.expect(berInteger)
+	if err != nil {
+		return 0, err
+	}
+	return berDecodeInt(content), nil
+}
+
+func (r *berReader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func berDecodeInt(content []byte) int {
+	x := 0
+	for i, b := range content {
+		if i == 0 && b&0x80 != 0 {
+			x = -1 // negative two's complement
+		}
+		x = x<<8 | int(b)
+	}
+	return x
+}
+
+func berDecodeUint(content []byte) uint {
+	var x uint
+	for _, b := range content {
+		x = x<<8 | uint(b)
+	}
+	return x
+}
+
+func berDecodeOid(content []byte) (string, error) {
+	if len(content) == 0 {
+		return "", errors.New("Empty OID")
+	}
+	var components []string
+	var x uint
+	for i, b := range content {
+		x = x<<7 | uint(b&0x7f)
+		if b&0x80 != 0 {
+			if i == len(content)-1 {
+				return "", errors.New("Truncated OID")
+			}
+			continue
+		}
+		if len(components) == 0 {
+			// first byte holds first 2 components
+			first := x / 40
+			if first > 2 {
+				first = 2
+			}
+			components = append(components, strconv.FormatUint(uint64(first), 10))
+			x -= first * 40
+		}
+		components = append(components, strconv.FormatUint(uint64(x), 10))
+		x = 0
+	}
+	return "." + strings.Join(components, "."), nil
+}
+
+func decodeSnmpPacket(data []byte) (packet *SnmpPacket, err error) {
+	packet = new(SnmpPacket)
+
+	top := &berReader{data: data}
+	msgContent, err := top.expect(berSequence)
+	if err != nil {
+		return nil, err
+	}
+	msg := &berReader{data: msgContent}
+	packet.version, err = msg.expectInt()
+	if err != nil {
+		return nil, err
+	}
+	if packet.version != snmpVersion1 && packet.version != snmpVersion2c {
+		// can't interpret the rest of it
+		return packet, nil
+	}
+	community, err := msg.expect(berOctetString)
+	if err != nil {
+		return nil, err
+	}
+	packet.community = string(community)
+
+	var pduContent []byte
+	packet.pduType, pduContent, err = msg.next()
+	if err != nil {
+		return nil, err
+	}
+	if packet.pduType == pduTrapV1 {
+		// different layout - don't need to look inside it
+		return packet, nil
+	}
+	pdu := &berReader{data: pduContent}
+	packet.requestId, err = pdu.expectInt()
+	if err != nil {
+		return nil, err
+	}
+	packet.errorStatus, err = pdu.expectInt()
+	if err != nil {
+		return nil, err
+	}
+	packet.errorIndex, err = pdu.expectInt()
+	if err != nil {
+		return nil, err
+	}
+	varbindsContent, err := pdu.expect(berSequence)
+	if err != nil {
+		return nil, err
+	}
+	varbinds := &berReader{data: varbindsContent}
+	for !varbinds.done() {
+		varbindContent, err := varbinds.expect(berSequence)
+		if err != nil {
+			return nil, err
+		}
+		varbind := &berReader{data: varbindContent}
+		oidContent, err := varbind.expect(berOid)
+		if err != nil {
+			return nil, err
+		}
+		var vb SnmpVarbind
+		vb.oid, err = berDecodeOid(oidContent)
+		if err != nil {
+			return nil, err
+		}
+		var valueContent []byte
+		vb.valueType, valueContent, err = varbind.next()
+		if err != nil {
+			return nil, err
+		}
+		vb.value, err = berDecodeValue(vb.valueType, valueContent)
+		if err != nil {
+			return nil, err
+		}
+		packet.varbinds = append(packet.varbinds, vb)
+	}
+	return packet, nil
+}
+
+func berDecodeValue(tag byte, content []byte) (interface{}, error) {
+	switch tag {
+	case berInteger:
+		return berDecodeInt(content), nil
+	case berCounter32, berGauge32, berTimeTicks, berCounter64:
+		return berDecodeUint(content), nil
+	case berOctetString, berOpaque:
+		return content, nil
+	case berOid:
+		return berDecodeOid(content)
+	case berIpAddress:
+		if len(content) != 4 {
+			return nil, errors.New("Invalid IpAddress length")
+		}
+		return fmt.Sprintf("%d.%d.%d.%d", content[0], content[1], content[2], content[3]), nil
+	}
+	// null and exceptions have no value
+	return nil, nil
+}
+
+//-------------------------------------------------------------------------------
+// encoding
+
+func berEncode(tag byte, content []byte) []byte {
+	length := len(content)
+	var header []byte
+	switch {
+	case length < 0x80:
+		header = []byte{tag, byte(length)}
+	case length < 0x100:
+		header = []byte{tag, 0x81, byte(length)}
+	default:
+		header = []byte{tag, 0x82, byte(length >> 8), byte(length)}
+	}
+	return append(header, content...)
+}
+
+func berEncodeInt(tag byte, x int) []byte {
+	// minimal two's complement
+	var content []byte
+	for {
+		content = append([]byte{byte(x)}, content...)
+		if (x >= -128 && x < 0 && content[0]&0x80 != 0) || (x >= 0 && x < 128) {
+			break
+		}
+		x >>= 8
+	}
+	return berEncode(tag, content)
+}
+
+func berEncodeUint(tag byte, x uint) []byte {
+	content := []byte{byte(x)}
+	for x >>= 8; x > 0; x >>= 8 {
+		content = append([]byte{byte(x)}, content...)
+	}
+	if content[0]&0x80 != 0 {
+		// keep it positive
+		content = append([]byte{0}, content...)
+	}
+	return berEncode(tag, content)
+}
+
+func berEncodeOid(oidStr string) ([]byte, error) {
+	oid, err := strToOID(oidStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("OID too short: %s", oidStr)
+	}
+	content := berBase128(oid[0]*40 + oid[1])
+	for _, component := range oid[2:] {
+		content = append(content, berBase128(component)...)
+	}
+	return berEncode(berOid, content), nil
+}
+
+func berBase128(x uint) []byte {
+	content := []byte{byte(x & 0x7f)}
+	for x >>= 7; x > 0; x >>= 7 {
+		content = append([]byte{byte(x&0x7f) | 0x80}, content...)
+	}
+	return content
+}
+
+func berEncodeVarbind(vb SnmpVarbind) ([]byte, error) {
+	name, err := berEncodeOid(vb.oid)
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	switch v := vb.value.(type) {
+	case int:
+		value = berEncodeInt(vb.valueType, v)
+	case uint:
+		value = berEncodeUint(vb.valueType, v)
+	case []byte:
+		value = berEncode(vb.valueType, v)
+	case string:
+		switch vb.valueType {
+		case berOid:
+			value, err = berEncodeOid(v)
+			if err != nil {
+				return nil, err
+			}
+		case berIpAddress:
+			addr, err := strToAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			value = berEncode(berIpAddress, addr[:])
+		default:
+			value = berEncode(vb.valueType, []byte(v))
+		}
+	default:
+		value = berEncode(vb.valueType, nil)
+	}
+	return berEncode(berSequence, append(name, value...)), nil
+}
+
+func berEncodeVarbinds(varbinds []SnmpVarbind) ([]byte, error) {
+	var content []byte
+	for _, vb := range varbinds {
+		encoded, err := berEncodeVarbind(vb)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, encoded...)
+	}
+	return berEncode(berSequence, content), nil
+}
+
+// encodeSnmpPacket encodes a non v1 trap message
+func encodeSnmpPacket(packet *SnmpPacket) ([]byte, error) {
+	varbinds, err := berEncodeVarbinds(packet.varbinds)
+	if err != nil {
+		return nil, err
+	}
+	var pdu []byte
+	pdu = append(pdu, berEncodeInt(berInteger, packet.requestId)...)
+	pdu = append(pdu, berEncodeInt(berInteger, packet.errorStatus)...)
+	pdu = append(pdu, berEncodeInt(berInteger, packet.errorIndex)...)
+	pdu = append(pdu, varbinds...)
+
+	var msg []byte
+	msg = append(msg, berEncodeInt(berInteger, packet.version)...)
+	msg = append(msg, berEncode(berOctetString, []byte(packet.community))...)
+	msg = append(msg, berEncode(packet.pduType, pdu)...)
+	return berEncode(berSequence, msg), nil
+}
+
+// encodeTrapV1 encodes a v1 trap message
+func encodeTrapV1(community string, enterprise string, agentAddr [4]byte, genericTrap int,
+	specificTrap int, ticks uint, varbinds []SnmpVarbind) ([]byte, error) {
+
+	enterpriseOid, err := berEncodeOid(enterprise)
+	if err != nil {
+		return nil, err
+	}
+	encodedVarbinds, err := berEncodeVarbinds(varbinds)
+	if err != nil {
+		return nil, err
+	}
+	var pdu []byte
+	pdu = append(pdu, enterpriseOid...)
+	pdu = append(pdu, berEncode(berIpAddress, agentAddr[:])...)
+	pdu = append(pdu, berEncodeInt(berInteger, genericTrap)...)
+	pdu = append(pdu, berEncodeInt(berInteger, specificTrap)...)
+	pdu = append(pdu, berEncodeUint(berTimeTicks, ticks)...)
+	pdu = append(pdu, encodedVarbinds...)
+
+	var msg []byte
+	msg = append(msg, berEncodeInt(berInteger, snmpVersion1)...)
+	msg = append(msg, berEncode(berOctetString, []byte(community))...)
+	msg = append(msg, berEncode(pduTrapV1, pdu)...)
+	return berEncode(berSequence, msg), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+)
+
+func printSnmpPacket(packet *SnmpPacket) {
+	fmt.Printf("version: %d community: %s pdu: %s id: %d error: %d/%d\n", packet.version, packet.community,
+		pduNames[packet.pduType], packet.requestId, packet.errorStatus, packet.errorIndex)
+	for _, vb := range packet.varbinds {
+		fmt.Printf("%s: 0x%02x %v\n", vb.oid, vb.valueType, vb.value)
+	}
+}
+
+func ExampleBer1() {
+	// snmpget -v1 -c public localhost 1.3.6.1.2.1.1.1.0
+	datagram := []byte{
+		0x30, 0x26, 0x02, 0x01, 0x00, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63, 0xa0, 0x19,
+		0x02, 0x01, 0x2a, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00, 0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08,
+		0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+	}
+	packet, err := decodeSnmpPacket(datagram)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printSnmpPacket(packet)
+
+	_, err = decodeSnmpPacket(datagram[:20])
+	fmt.Println(err)
+	// Output:
+	// version: 0 community: public pdu: get id: 42 error: 0/0
+	// .1.3.6.1.2.1.1.1.0: 0x05 <nil>
+	// BER length past end of data
+}
+
+func ExampleBer2() {
+	packet := &SnmpPacket{
+		version:     snmpVersion2c,
+		community:   "private",
+		pduType:     pduGetResponse,
+		requestId:   -1234567,
+		errorStatus: errNoAccess,
+		errorIndex:  2,
+		varbinds: []SnmpVarbind{
+			{".1.3.6.1.2.1.1.5.0", berOctetString, []byte("printer1")},
+			{".1.3.6.1.2.1.1.3.0", berTimeTicks, uint(4294967295)},
+			{".1.3.6.1.2.1.1.2.0", berOid, ".1.3.6.1.4.1.1129.2.3.45.1"},
+			{".1.3.6.1.2.1.4.20.1.1.10.100.63.22", berIpAddress, "10.100.63.22"},
+			{".1.3.6.1.2.1.25.3.5.1.1.1", berInteger, 128},
+			{".1.3.6.1.2.1.99.1.0", berNoSuchObj, nil},
+		},
+	}
+	datagram, err := encodeSnmpPacket(packet)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	packet, err = decodeSnmpPacket(datagram)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printSnmpPacket(packet)
+	// Output:
+	// version: 1 community: private pdu: response id: -1234567 error: 6/2
+	// .1.3.6.1.2.1.1.5.0: 0x04 [112 114 105 110 116 101 114 49]
+	// .1.3.6.1.2.1.1.3.0: 0x43 4294967295
+	// .1.3.6.1.2.1.1.2.0: 0x06 .1.3.6.1.4.1.1129.2.3.45.1
+	// .1.3.6.1.2.1.4.20.1.1.10.100.63.22: 0x40 10.100.63.22
+	// .1.3.6.1.2.1.25.3.5.1.1.1: 0x02 128
+	// .1.3.6.1.2.1.99.1.0: 0x80 <nil>
+}
+
+func ExampleBer3() {
+	trap, err := encodeTrapV1("public", ".1.3.6.1.4.1.1129", [4]byte{10, 0, 0, 1}, genericAuthenticationFailure, 0, 360000, nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("% x\n", trap)
+	// Output:
+	// 30 29 02 01 00 04 06 70 75 62 6c 69 63 a4 1c 06 07 2b 06 01 04 01 88 69 40 04 0a 00 00 01 02 01 04 02 01 00 43 03 05 7e 40 30 00
+}
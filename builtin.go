@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Builtin functions are keywords called like functions.
+// The parser checks the arguments against the builtin's parameter types
+// and the interpreter calls its Go function with their values.
+// Strings are indexed by character from 0.
+// The conversions are named after the type they make, e.g. intStr makes an integer of a string.
+
+// parameter type of the last parameter of a builtin taking any number of values of any type
+const anyValues = ValueNone
+
+type Builtin struct {
+	name       string
+	returnType ValueType
+	params     []ValueType
+	fn         func(args []*Value) (*Value, error)
+}
+
+type BuiltinCall struct {
+	builtin *Builtin
+	args    []*Expression
+	lineNum int
+}
+
+var builtins = map[itemType]*Builtin{
+	itemLen:     {"len", ValueInteger, []ValueType{ValueString}, builtinLen},
+	itemIndexOf: {"indexOf", ValueInteger, []ValueType{ValueString, ValueString}, builtinIndexOf},
+	itemSubstr:  {"substr", ValueString, []ValueType{ValueString, ValueInteger, ValueInteger}, builtinSubstr},
+	itemUpper:   {"upper", ValueString, []ValueType{ValueString}, builtinUpper},
+	itemLower:   {"lower", ValueString, []ValueType{ValueString}, builtinLower},
+	itemTrim:    {"trim", ValueString, []ValueType{ValueString}, builtinTrim},
+	itemReplace: {"replace", ValueString, []ValueType{ValueString, ValueString, ValueString}, builtinReplace},
+	itemRepeat:  {"repeat", ValueString, []ValueType{ValueString, ValueInteger}, builtinRepeat},
+	itemFormat:  {"format", ValueString, []ValueType{ValueString, anyValues}, builtinFormat},
+
+	itemIntStr:    {"intStr", ValueInteger, []ValueType{ValueString}, builtinIntStr},
+	itemOidStr:    {"oidStr", ValueOid, []ValueType{ValueString}, builtinOidStr},
+	itemAddrStr:   {"addrStr", ValueIpv4address, []ValueType{ValueString}, builtinAddrStr},
+	itemAddrInt:   {"addrInt", ValueIpv4address, []ValueType{ValueInteger}, builtinAddrInt},
+	itemIntAddr:   {"intAddr", ValueInteger, []ValueType{ValueIpv4address}, builtinIntAddr},
+	itemBitsetInt: {"bitsetInt", ValueBitset, []ValueType{ValueInteger}, builtinBitsetInt},
+	itemIntBitset: {"intBitset", ValueInteger, []ValueType{ValueBitset}, builtinIntBitset},
+
+	itemMin: {"min", ValueInteger, []ValueType{ValueInteger, ValueInteger}, builtinMin},
+	itemMax: {"max", ValueInteger, []ValueType{ValueInteger, ValueInteger}, builtinMax},
+	itemAbs: {"abs", ValueInteger, []ValueType{ValueInteger}, builtinAbs},
+
+	itemCount:   {"count", ValueInteger, []ValueType{ValueBitset}, builtinCount},
+	itemIsEmpty: {"isEmpty", ValueBoolean, []ValueType{ValueBitset}, builtinIsEmpty},
+	itemFirst:   {"first", ValueInteger, []ValueType{ValueBitset}, builtinFirst},
+}
+
+func intValue(x int) *Value {
+	return &Value{valueType: ValueInteger, intVal: x}
+}
+
+func stringValue(str string) *Value {
+	return &Value{valueType: ValueString, stringVal: str}
+}
+
+// len(s) is the number of characters
+func builtinLen(args []*Value) (*Value, error) {
+	return intValue(utf8.RuneCountInString(args[0].stringVal)), nil
+}
+
+// indexOf(s, sub) is where sub first starts in s or -1 if it isn't in it
+func builtinIndexOf(args []*Value) (*Value, error) {
+	str := args[0].stringVal
+	i := strings.Index(str, args[1].stringVal)
+	if i < 0 {
+		return intValue(-1), nil
+	}
+	return intValue(utf8.RuneCountInString(str[:i])), nil
+}
+
+// substr(s, start, length) is the length characters of s from start
+func builtinSubstr(args []*Value) (*Value, error) {
+	runes := []rune(args[0].stringVal)
+	start, length := args[1].intVal, args[2].intVal
+	// compare lengths rather than adding them which could overflow
+	if start < 0 || length < 0 || start > len(runes) || length > len(runes)-start {
+		return nil, fmt.Errorf("Substring of %d characters from %d is out of range of %d characters", length, start, len(runes))
+	}
+	return stringValue(string(runes[start : start+length])), nil
+}
+
+func builtinUpper(args []*Value) (*Value, error) {
+	return stringValue(strings.ToUpper(args[0].stringVal)), nil
+}
+
+func builtinLower(args []*Value) (*Value, error) {
+	return stringValue(strings.ToLower(args[0].stringVal)), nil
+}
+
+// trim(s) is s without leading and trailing white space
+func builtinTrim(args []*Value) (*Value, error) {
+	return stringValue(strings.TrimSpace(args[0].stringVal)), nil
+}
+
+// replace(s, old, new) replaces every old in s with new
+func builtinReplace(args []*Value) (*Value, error) {
+	return stringValue(strings.ReplaceAll(args[0].stringVal, args[1].stringVal, args[2].stringVal)), nil
+}
+
+// repeat(s, n) is s n times
+func builtinRepeat(args []*Value) (*Value, error) {
+	count := args[1].intVal
+	if count < 0 {
+		return nil, fmt.Errorf("Negative repeat count: %d", count)
+	}
+	str := args[0].stringVal
+	if count > 0 && len(str) > math.MaxInt/count {
+		return nil, fmt.Errorf("Repeat count %d is too large for %d bytes", count, len(str))
+	}
+	return stringValue(strings.Repeat(str, count)), nil
+}
+
+// format(f, ...) formats the values printf style
+// Integers are passed as numbers, booleans as booleans and the other types as their strings.
+func builtinFormat(args []*Value) (*Value, error) {
+	format := args[0].stringVal
+	verbs, err := formatVerbs(format)
+	if err != nil {
+		return nil, fmt.Errorf("Bad format %q: %s", format, err)
+	}
+	if len(verbs) != len(args)-1 {
+		return nil, fmt.Errorf("Bad format %q for %d values", format, len(args)-1)
+	}
+	values := make([]interface{}, len(args)-1)
+	for i, arg := range args[1:] {
+		allowed := "sqxXv" // other values are formatted as their text
+		switch arg.valueType {
+		case ValueInteger, ValueCounter, ValueTimeticks, ValueGuage:
+			values[i] = arg.intVal
+			allowed = "bcdoOqxXUv"
+		case ValueBoolean:
+			values[i] = arg.boolVal
+			allowed = "tv"
+		default:
+			values[i] = arg.text()
+		}
+		if !strings.ContainsRune(allowed, verbs[i]) {
+			return nil, fmt.Errorf("Bad format %q: %%%c for %s value %d", format, verbs[i], Type{valueType: arg.valueType}, i+1)
+		}
+	}
+	return stringValue(fmt.Sprintf(format, values...)), nil
+}
+
+// formatVerbs lists the verbs of a format string in order, skipping %%
+// Flags, width and precision are allowed but not * or explicit argument indexes.
+func formatVerbs(format string) (verbs []rune, err error) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i == len(format) {
+			return nil, errors.New("Missing verb at end")
+		}
+		verb, size := utf8.DecodeRuneInString(format[i:])
+		switch verb {
+		case '%':
+			continue
+		case '*', '[':
+			return nil, fmt.Errorf("Unsupported %%%c", verb)
+		}
+		verbs = append(verbs, verb)
+		i += size - 1
+	}
+	return verbs, nil
+}
+
+// intStr(s) is the decimal integer in s
+func builtinIntStr(args []*Value) (*Value, error) {
+	x, err := strconv.Atoi(strings.TrimSpace(args[0].stringVal))
+	if err != nil {
+		return nil, fmt.Errorf("Not an integer: %q", args[0].stringVal)
+	}
+	return intValue(x), nil
+}
+
+// oidStr(s) is the OID in s with or without its leading dot
+func builtinOidStr(args []*Value) (*Value, error) {
+	str := strings.TrimSpace(args[0].stringVal)
+	oid, err := strToOID(str)
+	if err != nil {
+		return nil, fmt.Errorf("Not an OID: %q", args[0].stringVal)
+	}
+	oidStr := ""
+	for _, component := range oid {
+		oidStr += fmt.Sprintf(".%d", component)
+	}
+	return &Value{valueType: ValueOid, oidVal: oidStr}, nil
+}
+
+// addrStr(s) is the dotted IPv4 address in s
+func builtinAddrStr(args []*Value) (*Value, error) {
+	ip := net.ParseIP(strings.TrimSpace(args[0].stringVal)).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("Not an IP address: %q", args[0].stringVal)
+	}
+	return &Value{valueType: ValueIpv4address, addrVal: ip.String()}, nil
+}
+
+// addrInt(x) is the IPv4 address whose 32 bits are x
+func builtinAddrInt(args []*Value) (*Value, error) {
+	x := args[0].intVal
+	if x < 0 || x > math.MaxUint32 {
+		return nil, fmt.Errorf("Integer %d is out of range of an IP address", x)
+	}
+	ip := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(ip, uint32(x))
+	return &Value{valueType: ValueIpv4address, addrVal: ip.String()}, nil
+}
+
+// intAddr(a) is the 32 bits of the address as an integer
+func builtinIntAddr(args []*Value) (*Value, error) {
+	ip := net.ParseIP(args[0].addrVal).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("Not an IP address: %q", args[0].addrVal)
+	}
+	return intValue(int(binary.BigEndian.Uint32(ip))), nil
+}
+
+// bitsetInt(x) is the bitset of the bits set in x, bit 0 being the lowest
+func builtinBitsetInt(args []*Value) (*Value, error) {
+	x := args[0].intVal
+	if x < 0 {
+		return nil, fmt.Errorf("Negative integer %d has no bitset", x)
+	}
+	bitset := make(BitsetMap)
+	for pos := uint(0); x != 0; pos++ {
+		if x&1 != 0 {
+			bitset[pos] = true
+		}
+		x >>= 1
+	}
+	return &Value{valueType: ValueBitset, bitsetVal: bitset}, nil
+}
+
+// intBitset(b) is the integer with the bits of the bitset set
+func builtinIntBitset(args []*Value) (*Value, error) {
+	x := 0
+	for pos, set := range args[0].bitsetVal {
+		if !set {
+			continue
+		}
+		if pos >= 63 {
+			return nil, fmt.Errorf("Bitset position %d is too large for an integer", pos)
+		}
+		x |= 1 << pos
+	}
+	return intValue(x), nil
+}
+
+func builtinMin(args []*Value) (*Value, error) {
+	if args[1].intVal < args[0].intVal {
+		return intValue(args[1].intVal), nil
+	}
+	return intValue(args[0].intVal), nil
+}
+
+func builtinMax(args []*Value) (*Value, error) {
+	if args[1].intVal > args[0].intVal {
+		return intValue(args[1].intVal), nil
+	}
+	return intValue(args[0].intVal), nil
+}
+
+func builtinAbs(args []*Value) (*Value, error) {
+	if args[0].intVal < 0 {
+		return intValue(-args[0].intVal), nil
+	}
+	return intValue(args[0].intVal), nil
+}
+
+// count(b) is the number of bits set
+func builtinCount(args []*Value) (*Value, error) {
+	return intValue(len(args[0].bitsetVal.bits())), nil
+}
+
+func builtinIsEmpty(args []*Value) (*Value, error) {
+	return &Value{valueType: ValueBoolean, boolVal: len(args[0].bitsetVal.bits()) == 0}, nil
+}
+
+// first(b) is the lowest bit set or -1 if there are none
+func builtinFirst(args []*Value) (*Value, error) {
+	bits := args[0].bitsetVal.bits()
+	if len(bits) == 0 {
+		return intValue(-1), nil
+	}
+	return intValue(bits[0]), nil
+}
+
+// parseBuiltinCall parses the arguments of the builtin whose keyword has been read
+func (parser *Parser) parseBuiltinCall(builtin *Builtin) (call *BuiltinCall, err error) {
+	call = new(BuiltinCall)
+	call.builtin = builtin
+	call.lineNum = parser.token.line
+
+	call.args, err = parser.parseArgs(builtin.name, builtin.params)
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+func PrintBuiltinCall(call *BuiltinCall, indent int) {
+	printfIndent(indent, "Builtin %s\n", call.builtin.name)
+	for i, arg := range call.args {
+		printfIndent(indent+1, "[%d] arg\n", i)
+		PrintExpression(arg, indent+1)
+	}
+}
+
+// interpBuiltinCall calls the builtin with the values of its arguments
+func (interp *Interpreter) interpBuiltinCall(call *BuiltinCall) (val *Value, err error) {
+	args := make([]*Value, len(call.args))
+	for i, arg := range call.args {
+		args[i], err = interp.interpExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	val, err = call.builtin.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("Line %d: %s: %v", call.lineNum, call.builtin.name, err)
+	}
+	return val, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+func ExampleBuiltin1() {
+	runProgramPrintError(`
+var
+  descr: string
+  cmd: string
+endvar
+run
+  descr = "  Toshiba e-STUDIO2555C  "
+  descr = trim(descr)
+  print "[" + descr + "] " + strInt(len(descr))
+  print upper(descr) + " " + lower(substr(descr, 8, 8))
+  print strInt(indexOf(descr, "e-STUDIO")) + " " + strInt(indexOf(descr, "HP"))
+  print replace("a-b-c", "-", "+") + " " + repeat("ab", 3)
+  cmd = "clear jam"
+  if cmd contains "jam" & !(cmd contains "paper") & len(cmd) = 9 & upper(cmd) = "CLEAR JAM"
+    print "jam cleared"
+  endif
+  print substr(descr, 20, 5)
+endrun`)
+	// builtin names are free for variables when not called
+	runProgramPrintError(`
+var
+  len: integer
+  upper: string
+endvar
+run
+  upper = "tray"
+  len = len(upper)
+  print upper(upper) + " " + strInt(len)
+endrun`)
+	runProgramPrintError(`
+var
+  descr: string
+endvar
+run
+  descr = substr(descr, 1)
+endrun`)
+	for _, bad := range []string{`descr = substr("abc", 0x7fffffffffffffff, 1)`, `descr = substr("abc", 2, 0x7fffffffffffffff)`, `descr = repeat("ab", 0x4000000000000000)`} {
+		runProgramPrintError(`
+var
+  descr: string
+endvar
+run
+  ` + bad + `
+endrun`)
+	}
+	// Output:
+	// [Toshiba e-STUDIO2555C] 21
+	// TOSHIBA E-STUDIO2555C e-studio
+	// 8 -1
+	// a+b+c ababab
+	// jam cleared
+	// Interpreting error: Line 17: substr: Substring of 5 characters from 20 is out of range of 21 characters
+	// TRAY 4
+	// Parsing error: test: Error at line 6: Wrong number of arguments calling substr (expecting 3)
+	// Interpreting error: Line 6: substr: Substring of 1 characters from 9223372036854775807 is out of range of 3 characters
+	// Interpreting error: Line 6: substr: Substring of 9223372036854775807 characters from 2 is out of range of 3 characters
+	// Interpreting error: Line 6: repeat: Repeat count 4611686018427387904 is too large for 2 bytes
+}
+
+func ExampleBuiltin2() {
+	runProgramPrintError(`
+var
+  name: string
+  pages: counter
+  ver: oid
+  peer: ipaddress
+  errors: bitset [0 = 'low paper', 1 = 'no paper']
+  ok: boolean
+endvar
+run
+  name = "tray"
+  pages = 42
+  ver = .1.3.6.1
+  peer = 10.0.0.1
+  errors = ['no paper']
+  ok = true
+  print format("%s: %d pages, errors %v", name, pages, errors)
+  print format("%-6s|%5d%%|%t|%x", name, 12, ok, 255)
+  print "${name} at ${peer} ${ver} ok=${ok} costs $$${pages} or $5"
+  print "col1\tcol2\x41\102\\\"q\""
+  print format("%s", "50%!")
+  print format("%d")
+endrun`)
+	runProgramPrintError(`
+run
+  print format("%d%%", "tray")
+endrun`)
+	runProgramPrintError(`
+run
+  print format("%5.1", 42)
+endrun`)
+	runProgramPrintError(`
+var
+  name: string
+endvar
+run
+  print "${nmae}"
+endrun`)
+	runProgramPrintError(`
+run
+  print "bad \q escape"
+endrun`)
+	// Output:
+	// tray: 42 pages, errors {1}
+	// tray  |   12%|true|ff
+	// tray at 10.0.0.1 .1.3.6.1 ok=true costs $42 or $5
+	// col1	col2AB\"q"
+	// 50%!
+	// Interpreting error: Line 22: format: Bad format "%d" for 0 values
+	// Interpreting error: Line 3: format: Bad format "%d%%": %d for String value 1
+	// Interpreting error: Line 3: format: Bad format "%5.1": Missing verb at end
+	// Parsing error: test: Error at line 6: Unknown variable in string: nmae
+	// Parsing error: test: Error at line 3: Invalid escape \q in string
+}
+
+func ExampleBuiltin3() {
+	prog := `
+var
+  input: string
+  n: integer
+  ver: oid
+  peer: ipaddress
+  flags: bitset [0 = 'low paper', 1 = 'no paper', 3 = 'jam']
+endvar
+run
+  input = " 42 "
+  n = intStr(input) + 1
+  ver = oidStr("1.3.6.1.4") + .1
+  peer = addrStr("192.168.1.20")
+  print strInt(n) + " " + strOid(ver) + " " + strIpaddress(peer)
+  print strInt(intAddr(peer)) + " " + strIpaddress(addrInt(167772161))
+  flags = bitsetInt(10)
+  print strBitset(flags) + " " + strInt(intBitset(flags + ['low paper']))
+  if addrStr("10.0.0.1") = addrInt(intAddr(10.0.0.1)) & oidStr(".1.3") = .1.3
+    print "round trips"
+  endif
+  n = intStr("4x2")
+endrun`
+	runProgramPrintError(prog)
+	for _, bad := range []string{`ver = oidStr("1.3.x")`, `peer = addrStr("10.0.0")`, `peer = addrInt(-1)`, `flags = bitsetInt(-2)`, `n = intBitset([63])`} {
+		runProgramPrintError(`
+var
+  n: integer
+  ver: oid
+  peer: ipaddress
+  flags: bitset [0 = 'low paper']
+endvar
+run
+  ` + bad + `
+endrun`)
+	}
+	// Output:
+	// 43 .1.3.6.1.4.1 192.168.1.20
+	// 3232235796 10.0.0.1
+	// {1, 3} 11
+	// round trips
+	// Interpreting error: Line 21: intStr: Not an integer: "4x2"
+	// Interpreting error: Line 9: oidStr: Not an OID: "1.3.x"
+	// Interpreting error: Line 9: addrStr: Not an IP address: "10.0.0"
+	// Interpreting error: Line 9: addrInt: Integer -1 is out of range of an IP address
+	// Interpreting error: Line 9: bitsetInt: Negative integer -2 has no bitset
+	// Interpreting error: Line 9: intBitset: Bitset position 63 is too large for an integer
+}
@@ -0,0 +1,32 @@
+package main
+
+import "sync"
+
+// Changes of the program's values for statements waiting on a condition
+// Waiting statements are woken by closing the changed channel, which is then replaced.
+
+type Changes struct {
+	lock    sync.Mutex
+	changed chan struct{}
+}
+
+func newChanges() *Changes {
+	return &Changes{changed: make(chan struct{})}
+}
+
+// notify wakes the statements waiting for a change
+func (changes *Changes) notify() {
+	changes.lock.Lock()
+	defer changes.lock.Unlock()
+
+	close(changes.changed)
+	changes.changed = make(chan struct{})
+}
+
+// next gets the channel closed by the next change
+func (changes *Changes) next() <-chan struct{} {
+	changes.lock.Lock()
+	defer changes.lock.Unlock()
+
+	return changes.changed
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/PromonLogicalis/snmp"
+)
+
+// Communities with their own view of the MIB, access rights and allowed sources
+// Each is given on the command line as:
+//   name[=ro|rw][;view=[+|-]oid,...][;from=cidr,...]
+// e.g.
+// -community public
+// -community private=rw;view=1.3.6.1.2.1.1,-1.3.6.1.2.1.1.9
+// -community monitor=ro;view=-1.3.6.1.2.1.4;from=10.0.0.0/8,127.0.0.1
+//
+// A view is a list of included (+) and excluded (-) subtrees where the longest
+// matching subtree decides. OIDs matching none are only in the view if it has no includes.
+
+type ViewSubtree struct {
+	oid      string // with leading dot
+	included bool
+}
+
+type Community struct {
+	name     string
+	writable bool
+	view     []ViewSubtree // empty for everything
+	sources  []*net.IPNet  // empty for anywhere
+	agent    *snmp.Agent   // serving just the OIDs in the view
+}
+
+type Communities []*Community
+
+func (communities *Communities) String() string {
+	var names []string
+	for _, community := range *communities {
+		names = append(names, community.name)
+	}
+	return fmt.Sprintf("communities: %v\n", names)
+}
+
+// Set adds a community from its flag value
+func (communities *Communities) Set(value string) error {
+	community, err := parseCommunity(value)
+	if err != nil {
+		return err
+	}
+	*communities = append(*communities, community)
+	return nil
+}
+
+func parseCommunity(spec string) (*Community, error) {
+	fields := strings.Split(spec, ";")
+	community := new(Community)
+
+	nameAccess := strings.SplitN(fields[0], "=", 2)
+	community.name = nameAccess[0]
+	if len(community.name) == 0 {
+		return nil, errors.New("Missing community name")
+	}
+	if len(nameAccess) == 2 {
+		switch nameAccess[1] {
+		case "ro":
+		case "rw":
+			community.writable = true
+		default:
+			return nil, fmt.Errorf("Invalid community access %s (expecting ro or rw)", nameAccess[1])
+		}
+	}
+
+	for _, field := range fields[1:] {
+		keyValue := strings.SplitN(field, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("Invalid community option %s", field)
+		}
+		switch keyValue[0] {
+		case "view":
+			for _, subtreeStr := range strings.Split(keyValue[1], ",") {
+				subtree := ViewSubtree{included: true}
+				if strings.HasPrefix(subtreeStr, "-") {
+					subtree.included = false
+				}
+				oidStr := strings.TrimPrefix(strings.TrimLeft(subtreeStr, "+-"), ".")
+				if err := isValidOID(oidStr); err != nil {
+					return nil, fmt.Errorf("Invalid view subtree %s: %v", subtreeStr, err)
+				}
+				subtree.oid = "." + oidStr
+				community.view = append(community.view, subtree)
+			}
+		case "from":
+			for _, sourceStr := range strings.Split(keyValue[1], ",") {
+				if !strings.Contains(sourceStr, "/") {
+					// single address
+					ip := net.ParseIP(sourceStr)
+					if ip == nil {
+						return nil, fmt.Errorf("Invalid source address %s", sourceStr)
+					}
+					bits := 8 * net.IPv6len
+					if ip.To4() != nil {
+						ip, bits = ip.To4(), 8*net.IPv4len
+					}
+					community.sources = append(community.sources, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+					continue
+				}
+				_, ipNet, err := net.ParseCIDR(sourceStr)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid source network %s: %v", sourceStr, err)
+				}
+				community.sources = append(community.sources, ipNet)
+			}
+		default:
+			return nil, fmt.Errorf("Unknown community option %s", keyValue[0])
+		}
+	}
+	return community, nil
+}
+
+// inView reports whether the OID is visible to the community
+func (community *Community) inView(oidStr string) bool {
+	if len(community.view) == 0 {
+		return true
+	}
+	matchLen := -1
+	included := true
+	for _, subtree := range community.view {
+		if subtree.included {
+			included = false // only what is included when there are includes
+			break
+		}
+	}
+	for _, subtree := range community.view {
+		if (oidStr == subtree.oid || strings.HasPrefix(oidStr, subtree.oid+".")) && len(subtree.oid) > matchLen {
+			matchLen = len(subtree.oid)
+			included = subtree.included
+		}
+	}
+	return included
+}
+
+// allowedFrom reports whether the community may be used from the source address
+func (community *Community) allowedFrom(source net.Addr) bool {
+	if len(community.sources) == 0 {
+		return true
+	}
+	udpAddr, ok := source.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range community.sources {
+		if ipNet.Contains(udpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// initAgent creates the community's agent with the OIDs in its view
+func (community *Community) initAgent(interp *Interpreter) {
+	community.agent = snmp.NewAgent()
+	community.agent.SetCommunities(community.name, community.name)
+
+	for oidStr := range interp.oid2Values {
+		if !community.inView(oidStr) {
+			continue
+		}
+		snmpMode := interp.staticModes[oidStr] // static values such as from dumps
+		if typ, ok := interp.variables.typesFromOid[oidStr]; ok {
+			snmpMode = typ.snmpMode
+		}
+		if !community.writable {
+			snmpMode = SnmpModeRead
+		}
+		addOIDFunc(community.agent, interp, oidStr, snmpMode)
+	}
+}
+
+// findCommunity returns the first community of the name allowed from the source
+// or nil if there is none
+func findCommunity(communities Communities, name string, source net.Addr) *Community {
+	for _, community := range communities {
+		if community.name == name && community.allowedFrom(source) {
+			return community
+		}
+	}
+	return nil
+}
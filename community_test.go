@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func ExampleCommunity1() {
+	var communities Communities
+	for _, spec := range []string{
+		"public",
+		"private=rw;view=1.3.6.1.2.1.1,-1.3.6.1.2.1.1.9",
+		"monitor=ro;view=-.1.3.6.1.2.1.4;from=10.0.0.0/8,127.0.0.1",
+		"=rw",
+		"bad=rx",
+		"bad;view=1.3.x",
+		"bad;from=10.0.0.300",
+		"bad;size=3",
+	} {
+		err := communities.Set(spec)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+
+	oids := []string{".1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.9.1.2.1", ".1.3.6.1.2.1.11.1.0", ".1.3.6.1.2.1.4.20.1.1.10.100.63.22"}
+	for _, community := range communities {
+		fmt.Printf("%s %v:", community.name, community.writable)
+		for _, oidStr := range oids {
+			fmt.Printf(" %v", community.inView(oidStr))
+		}
+		fmt.Println()
+	}
+
+	for _, ip := range []net.IP{net.IPv4(10, 1, 2, 3), net.IPv4(127, 0, 0, 1), net.IPv4(192, 168, 1, 1)} {
+		source := &net.UDPAddr{IP: ip, Port: 1161}
+		fmt.Println(ip, findCommunity(communities, "monitor", source) != nil)
+	}
+	// Output:
+	// Missing community name
+	// Invalid community access rx (expecting ro or rw)
+	// Invalid view subtree 1.3.x: strconv.ParseUint: parsing "x": invalid syntax
+	// Invalid source address 10.0.0.300
+	// Unknown community option size
+	// public false: true true true true
+	// private true: true false false false
+	// monitor false: true true true false
+	// 10.1.2.3 true
+	// 127.0.0.1 true
+	// 192.168.1.1 false
+}
+
+func ExampleCommunity2() {
+	interp := runTestProgram(`
+var
+  desc: 2.1.1.1.0 string
+endvar
+run
+  desc = "Toshiba 2555c"
+endrun`)
+	communities := Communities{
+		{name: "public"},
+		{name: "private", writable: true, view: []ViewSubtree{{".1.3.6.1.2.1.1.5", true}}},
+	}
+	server := &SnmpServer{interp: interp, stats: new(SnmpStats), communities: communities}
+	source := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1161}
+
+	for _, request := range []*SnmpPacket{
+		{version: snmpVersion1, community: "public", pduType: pduSetRequest, requestId: 1},
+		{version: snmpVersion2c, community: "public", pduType: pduSetRequest, requestId: 2},
+		{version: snmpVersion1, community: "private", pduType: pduSetRequest, requestId: 3},
+		{version: snmpVersion2c, community: "private", pduType: pduSetRequest, requestId: 4},
+	} {
+		request.varbinds = []SnmpVarbind{
+			{".1.3.6.1.2.1.1.5.0", berOctetString, []byte("printer1")},
+			{".1.3.6.1.2.1.1.1.0", berOctetString, []byte("Toshiba")},
+		}
+		datagram, err := encodeSnmpPacket(request)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		datagram, err = server.processDatagram(datagram, source)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		response, err := decodeSnmpPacket(datagram)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%d: %s error: %d/%d\n", response.requestId, pduNames[response.pduType], response.errorStatus, response.errorIndex)
+	}
+	// Output:
+	// 1: response error: 2/1
+	// 2: response error: 16/0
+	// 3: response error: 2/2
+	// 4: response error: 6/2
+}
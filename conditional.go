@@ -0,0 +1,82 @@
+package main
+
+// Conditional expressions choose between two expressions of the type of the
+// expression they are in, e.g. x = if do-color then 1 else 0
+// The else expression takes the rest of the surrounding expression unless it is bracketed.
+// Where any type of expression can go, e.g. as an argument of format, the then expression gives the type.
+
+type Conditional struct {
+	condition *BoolExpression
+	thenExprn *Expression
+	elseExprn *Expression
+}
+
+//<conditional>::=if <bool-expression> then <expression> else <expression>
+//                where both expressions are of the value type
+func (parser *Parser) parseConditional(valueType ValueType) (cond *Conditional, err error) {
+	cond = new(Conditional)
+
+	cond.condition, err = parser.parseBoolExpression()
+	if err != nil {
+		return nil, err
+	}
+	// then is not a keyword so it can still name a variable
+	if !parser.peekWord("then") {
+		item := parser.nextItem()
+		return nil, parser.errorf("Expecting then in conditional expression but got \"%v\"", item.typ)
+	}
+	parser.nextItem()
+	if valueType == ValueNone {
+		valueType = parser.peekValueType()
+		if valueType == ValueNone {
+			parser.nextItem()
+			return nil, parser.errorf("Can not tell the type of expression")
+		}
+	}
+	cond.thenExprn, err = parser.parseBranch(valueType)
+	if err != nil {
+		return nil, err
+	}
+	err = parser.match(itemElse, "conditional expression")
+	if err != nil {
+		return nil, err
+	}
+	cond.elseExprn, err = parser.parseBranch(valueType)
+	if err != nil {
+		return nil, err
+	}
+	return cond, nil
+}
+
+// parseBranch parses an expression of a conditional checking it is of the value type
+func (parser *Parser) parseBranch(valueType ValueType) (exprn *Expression, err error) {
+	// a boolean expression may start with a value of any type being compared
+	if valueType == ValueBoolean {
+		return parser.parseTypedExpression(valueType)
+	}
+	if branchType, ok := parser.peekCompatible(valueType); !ok {
+		return nil, parser.errorf("Conditional expression of %v has a branch of %v", Type{valueType: valueType}, Type{valueType: branchType})
+	}
+	return parser.parseTypedExpression(valueType)
+}
+
+func PrintConditional(cond *Conditional, indent int) {
+	printfIndent(indent, "Conditional\n")
+	PrintBooleanExpression(cond.condition, indent+1)
+	printfIndent(indent, "Then\n")
+	PrintExpression(cond.thenExprn, indent+1)
+	printfIndent(indent, "Else\n")
+	PrintExpression(cond.elseExprn, indent+1)
+}
+
+// interpConditional gets the value of the expression chosen by the condition
+func (interp *Interpreter) interpConditional(cond *Conditional) (val *Value, err error) {
+	b, err := interp.interpBoolExpression(cond.condition)
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		return interp.interpExpression(cond.thenExprn)
+	}
+	return interp.interpExpression(cond.elseExprn)
+}
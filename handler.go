@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Handlers run the statements of an "on set" block each time a manager sets the variable.
+// The SNMP server only queues the change so it never waits on the program,
+// and each handler's goroutine runs its queued changes in order.
+//
+// An "on get" block is run by the SNMP server before it reads the variable,
+// but the server waits no longer than the time budget for it.
+
+// how long the SNMP server waits for an on get handler
+const getHandlerBudget = 200 * time.Millisecond
+
+type SetEvent struct {
+	oldVal    *Value
+	newVal    *Value
+	requester string // address of the manager
+}
+
+type GetHandler struct {
+	handler *Handler
+	running int32 // 1 while running, accessed atomically
+}
+
+type HandlerQueue struct {
+	lock   sync.Mutex
+	events []*SetEvent
+	ready  chan struct{} // signalled when events are added
+}
+
+func newHandlerQueue() *HandlerQueue {
+	return &HandlerQueue{ready: make(chan struct{}, 1)}
+}
+
+func (queue *HandlerQueue) push(event *SetEvent) {
+	queue.lock.Lock()
+	queue.events = append(queue.events, event)
+	queue.lock.Unlock()
+
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+		// already signalled
+	}
+}
+
+// popAll takes the queued events
+func (queue *HandlerQueue) popAll() []*SetEvent {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	events := queue.events
+	queue.events = nil
+	return events
+}
+
+// initHandlers sets up the handlers' state and the poll counts of the OIDs
+func (interp *Interpreter) initHandlers(prog *Program) {
+	interp.setHandlers = make(map[string]*HandlerQueue)
+	interp.getHandlers = make(map[string]*GetHandler)
+	for _, handler := range prog.handlers {
+		switch handler.handlerType {
+		case HandlerSet:
+			interp.setHandlers[handler.identifier] = newHandlerQueue()
+		case HandlerGet:
+			interp.getHandlers[handler.identifier] = &GetHandler{handler: handler}
+		}
+	}
+
+	interp.polls = newPolls(interp.variables)
+}
+
+// SetRequester records the source of the request being processed by the agent
+func (interp *Interpreter) SetRequester(source net.Addr) {
+	if udpAddr, ok := source.(*net.UDPAddr); ok {
+		interp.requester.Store(udpAddr.IP.String())
+		return
+	}
+	interp.requester.Store(source.String())
+}
+
+// notifySet queues a manager's change of the variable for any handler of it
+func (interp *Interpreter) notifySet(id string, oldVal *Value, newVal *Value) {
+	queue, ok := interp.setHandlers[id]
+	if !ok {
+		return
+	}
+	queue.push(&SetEvent{oldVal: oldVal, newVal: newVal, requester: interp.requester.Load().(string)})
+}
+
+// runHandler runs the on set handler's statements for each change until the program stops
+func (interp *Interpreter) runHandler(handler *Handler) error {
+	queue := interp.setHandlers[handler.identifier]
+	for {
+		select {
+		case <-queue.ready:
+		case <-interp.stop:
+			return errStopped
+		}
+
+		for _, event := range queue.popAll() {
+			err := interp.interpHandler(handler, map[string]*Value{
+				handlerOldId:       event.oldVal,
+				handlerNewId:       event.newVal,
+				handlerRequesterId: {valueType: ValueString, stringVal: event.requester},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// interpHandler runs the handler's statements with the values of its locals
+func (interp *Interpreter) interpHandler(handler *Handler, values map[string]*Value) error {
+	frame := &Frame{types: make(map[string]*Type), values: values}
+	for _, local := range handler.locals {
+		frame.types[local.id] = local
+	}
+	interp.frame = frame
+	defer func() { interp.frame = nil }()
+
+	_, err := interp.interpStatementList(handler.stmtList)
+	return err
+}
+
+// pollOid runs any on get handler of the variable of the OID a manager is reading
+// waiting for it no longer than the budget
+func (interp *Interpreter) pollOid(oidStr string) {
+	typ, ok := interp.variables.typesFromOid[oidStr]
+	if !ok {
+		return
+	}
+	getHandler, ok := interp.getHandlers[typ.id]
+	if !ok {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&getHandler.running, 0, 1) {
+		return // still running for an earlier read
+	}
+
+	done := make(chan struct{})
+	requester := interp.requester.Load().(string)
+	go func(handlerInterp *Interpreter) {
+		defer close(done)
+		defer atomic.StoreInt32(&getHandler.running, 0)
+		err := handlerInterp.interpHandler(getHandler.handler, map[string]*Value{
+			handlerRequesterId: {valueType: ValueString, stringVal: requester},
+		})
+		if err != nil && err != errStopped {
+			logger.Printf("%v: %v\n", getHandler.handler, err)
+		}
+	}(interp.fork())
+
+	timer := time.NewTimer(getHandlerBudget)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		logger.Printf("%v: Exceeded time budget of %v\n", getHandler.handler, getHandlerBudget)
+	}
+}
+
+// polled counts a manager having read the value of the OID
+// waking wait poll statements only once the value has been collected
+func (interp *Interpreter) polled(oidStr string) {
+	interp.polls.incr(oidStr)
+	interp.changes.notify() // for any wait until on polls()
+}
+
+// pollCount gets how many times a manager has read the variable
+func (interp *Interpreter) pollCount(id string) int {
+	typ := interp.variables.types[id]
+	return interp.polls.count(typ.oid)
+}
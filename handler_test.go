@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+// start a program running in the background
+// The program's error is sent on the channel when it ends.
+func startTestProgram(progStr string) (*Interpreter, chan error) {
+	interp, program, err := initTestProgram(progStr)
+	if err != nil {
+		fmt.Printf("Parsing error: %s\n", err)
+		os.Exit(1)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- interp.InterpProgram(program)
+	}()
+	return interp, done
+}
+
+// set a variable's OID as the agent does for a manager's set request
+func setTestValue(interp *Interpreter, source net.Addr, oidStr string, value interface{}) {
+	oid, _ := strToOID(oidStr)
+	typ := interp.variables.typesFromOid[oidStr]
+	interp.SetRequester(source)
+	err := oidWriteFunc(interp, typ.snmpMode)(oid, value)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// get a variable's OID as the agent does for a manager's get request
+func getTestValue(interp *Interpreter, oidStr string) {
+	oid, _ := strToOID(oidStr)
+	value, err := oidReadFunc(interp)(oid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(oidStr, value)
+}
+
+func ExampleHandler1() {
+	interp, done := startTestProgram(`
+var
+  lamp-mode: 4.1.1.1.0 rw integer
+  sets: integer
+endvar
+on set lamp-mode
+  print "lamp-mode " + strInt(old) + " -> " + strInt(new) + " by " + requester
+  sets = sets + 1
+endon
+run
+  sleep 200 msecs
+  print "lamp-mode is " + strInt(lamp-mode) + " after " + strInt(sets) + " sets"
+endrun`)
+
+	source := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 1161}
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.1.0", 3)
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.1.0", 2)
+
+	err := <-done
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// lamp-mode 0 -> 3 by 10.0.0.5
+	// lamp-mode 3 -> 2 by 10.0.0.5
+	// lamp-mode is 2 after 2 sets
+}
+
+func ExampleHandler2() {
+	for _, prog := range []string{`
+var
+  lamp-mode: 4.1.1.1.0 integer
+endvar
+on set lamp-mode
+endon
+run
+endrun`, `
+var
+  lamp-mode: 4.1.1.1.0 rw integer
+endvar
+on change lamp-mode
+endon
+run
+endrun`} {
+		_, err := NewParser(lex("test", prog)).ParseProgram()
+		fmt.Println(err)
+	}
+	// Output:
+	// test: Error at line 5: On set of non rw OID variable: lamp-mode
+	// test: Error at line 5: Expecting set or get after on but got "change"
+}
+
+func ExampleHandler3() {
+	logger = log.New(os.Stdout, "", 0)
+	interp, done := startTestProgram(`
+var
+  requests: 4.1.1.2.0 counter
+  slow: 4.1.1.3.0 integer
+endvar
+on get requests
+  requests = requests + 1
+endon
+on get slow
+  sleep 1 secs
+  slow = 99
+endon
+run
+  sleep 500 msecs
+  print "requests polled " + strInt(polls(requests)) + " times, slow " + strInt(polls(slow)) + " times"
+endrun`)
+
+	getTestValue(interp, ".1.3.6.1.4.1.1.2.0")
+	getTestValue(interp, ".1.3.6.1.4.1.1.2.0")
+	getTestValue(interp, ".1.3.6.1.4.1.1.2.0")
+	getTestValue(interp, ".1.3.6.1.4.1.1.3.0")
+	getTestValue(interp, ".1.3.6.1.4.1.1.3.0")
+
+	err := <-done
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// .1.3.6.1.4.1.1.2.0 1
+	// .1.3.6.1.4.1.1.2.0 2
+	// .1.3.6.1.4.1.1.2.0 3
+	// On get slow at line 9: Exceeded time budget of 200ms
+	// .1.3.6.1.4.1.1.3.0 0
+	// .1.3.6.1.4.1.1.3.0 0
+	// requests polled 3 times, slow 2 times
+}
+
+func ExampleHandler4() {
+	interp, done := startTestProgram(`
+var
+  state: 4.1.1.4.0 integer
+  late: boolean
+endvar
+run
+  state = 1
+  wait poll state
+  state = 2
+  wait poll state times 2 timeout 5 secs timedout late
+  print "collected state 2 late " + strBool(late)
+  state = 3
+  wait poll state timeout 100 msecs timedout late
+  print "collected state 3 late " + strBool(late)
+endrun`)
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+		getTestValue(interp, ".1.3.6.1.4.1.1.4.0")
+	}
+
+	err := <-done
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// .1.3.6.1.4.1.1.4.0 1
+	// .1.3.6.1.4.1.1.4.0 2
+	// .1.3.6.1.4.1.1.4.0 2
+	// collected state 2 late false
+	// collected state 3 late true
+}
+
+func ExampleHandler5() {
+	// polls is only a keyword when called
+	runProgramPrintError(`
+var
+  requests: 4.1.1.2.0 counter
+  polls: integer
+endvar
+run
+  polls = polls(requests) + 5
+  print strInt(polls)
+endrun`)
+	// Output:
+	// 5
+}
+
+func ExampleHandler6() {
+	// timeout and timedout are only words of a wait
+	runProgramPrintError(`
+var
+  state: 4.1.1.4.0 integer
+  timeout: integer
+  timedout: boolean
+endvar
+run
+  timeout = 50
+  wait poll state timeout timeout msecs timedout timedout
+  print strInt(timeout) + " " + strBool(timedout)
+endrun`)
+	// Output:
+	// 50 true
+}
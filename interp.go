@@ -2,13 +2,17 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -37,17 +41,76 @@ func (v *Value) String() string {
 		str += fmt.Sprintf("<Bitset: %v>", v.bitsetVal)
 	case ValueOid:
 		str += fmt.Sprintf("<OID: %s>", v.oidVal)
+	case ValueCounter:
+		str += fmt.Sprintf("<Counter: %d>", v.intVal)
+	case ValueTimeticks:
+		str += fmt.Sprintf("<Timeticks: %d>", v.intVal)
+	case ValueGuage:
+		str += fmt.Sprintf("<Guage: %d>", v.intVal)
+	case ValueIpv4address:
+		str += fmt.Sprintf("<Ipaddress: %s>", v.addrVal)
+	case ValueBytes:
+		str += fmt.Sprintf("<Bytes: %v>", v.bytesVal)
 	case ValueNone:
 		str += "<none>"
 	}
 	return str
 }
 
+// text is the value as it is printed in a string
+func (v *Value) text() string {
+	switch v.valueType {
+	case ValueBoolean:
+		return strconv.FormatBool(v.boolVal)
+	case ValueInteger, ValueCounter, ValueTimeticks, ValueGuage:
+		return strconv.Itoa(v.intVal)
+	case ValueString:
+		return v.stringVal
+	case ValueBitset:
+		return v.bitsetVal.String()
+	case ValueOid:
+		return v.oidVal
+	case ValueIpv4address:
+		return v.addrVal
+	case ValueBytes:
+		return v.bytesVal.String()
+	}
+	return ""
+}
+
 type Interpreter struct {
-	variables  *Variables
-	values     map[string]*Value // variable id --> Value
-	oid2Values map[string]*Value // oid --> Value
-	valLock    sync.RWMutex
+	variables   *Variables
+	values      map[string]*Value        // variable id --> Value
+	oid2Values  map[string]*Value        // oid --> Value
+	staticModes map[string]SnmpMode      // oid --> mode for OIDs which are not program variables
+	liveValues  map[string]func() *Value // oid --> Value computed at the time it is read
+	valLock     *sync.RWMutex            // shared by the tasks
+	frame       *Frame                   // locals of the procedure being run, nil in the run block
+	stop        chan struct{}            // closed to stop the tasks when the run block ends
+	stopOnce    *sync.Once               // stop is closed once
+	taskDone    map[string]chan struct{} // task name --> closed when it ends
+	setHandlers map[string]*HandlerQueue // variable id --> changes for its on set handler
+	getHandlers map[string]*GetHandler   // variable id --> its on get handler
+	polls       *Polls                   // times the variables have been read by managers
+	setQueues   map[string]*SetQueue     // rwb variable id --> sets waiting to be read
+	changes     *Changes                 // changes of values for wait until statements
+	requester   *atomic.Value            // address of the source of the SNMP request being processed
+}
+
+// errStopped ends a task's statements when the program is stopped
+var errStopped = errors.New("Program stopped")
+
+// maximum depth of nested procedure calls
+const maxCallDepth = 1000
+
+// Frame holds the parameters of a procedure or function call
+// It is only used by the goroutine running the call so needs no locking.
+type Frame struct {
+	types       map[string]*Type
+	values      map[string]*Value
+	depth       int
+	returned    bool   // a return statement has been run
+	returnValue *Value // of a function
 }
 
 // GetValueForOid is a thread safe version of getting value from oid map
@@ -55,14 +118,42 @@ func (interp *Interpreter) GetValueForOid(oidStr string) (val *Value, found bool
 	interp.valLock.RLock()
 	defer interp.valLock.RUnlock()
 
-	val, found = interp.oid2Values[oidStr]
+	val, found = interp.currentValue(oidStr)
 	if !found {
 		return nil, false
 	}
 	return val, true
 }
 
+// currentValue gets the value for the oid allowing for live values
+// Must be called with the lock held
+func (interp *Interpreter) currentValue(oidStr string) (val *Value, found bool) {
+	if liveFunc, ok := interp.liveValues[oidStr]; ok {
+		return liveFunc(), true
+	}
+	val, found = interp.oid2Values[oidStr]
+	return val, found
+}
+
+// OidValues returns a copy of the oid to value map taken under the lock
+func (interp *Interpreter) OidValues() map[string]*Value {
+	interp.valLock.RLock()
+	defer interp.valLock.RUnlock()
+
+	vals := make(map[string]*Value, len(interp.oid2Values))
+	for oidStr := range interp.oid2Values {
+		vals[oidStr], _ = interp.currentValue(oidStr)
+	}
+	return vals
+}
+
 func (interp *Interpreter) GetValueForId(id string) (val *Value, found bool) {
+	if interp.frame != nil {
+		if val, found = interp.frame.values[id]; found {
+			return val, true
+		}
+	}
+
 	interp.valLock.RLock()
 	defer interp.valLock.RUnlock()
 
@@ -82,6 +173,21 @@ func (interp *Interpreter) SetValueForIdOid(id string, oidStr string, val *Value
 		interp.oid2Values[oidStr] = val
 	}
 	interp.values[id] = val
+	interp.changes.notify()
+}
+
+// swapValueForIdOid sets the value returning the previous one
+func (interp *Interpreter) swapValueForIdOid(id string, oidStr string, val *Value) (oldVal *Value) {
+	interp.valLock.Lock()
+	defer interp.valLock.Unlock()
+
+	oldVal = interp.values[id]
+	if len(oidStr) > 0 {
+		interp.oid2Values[oidStr] = val
+	}
+	interp.values[id] = val
+	interp.changes.notify()
+	return oldVal
 }
 
 func textToValue(text string, val *Value, variables *Variables) error {
@@ -193,10 +299,69 @@ func (interp *Interpreter) Init(prog *Program, varInits VariableInits) {
 	/* initialise variables based on the types */
 	interp.values = make(map[string]*Value)
 	interp.oid2Values = make(map[string]*Value)
+	interp.staticModes = make(map[string]SnmpMode)
+	interp.liveValues = make(map[string]func() *Value)
+	interp.valLock = new(sync.RWMutex)
+	interp.requester = new(atomic.Value)
+	interp.requester.Store("")
+	interp.stop = make(chan struct{})
+	interp.stopOnce = new(sync.Once)
+	interp.changes = newChanges()
+	interp.taskDone = make(map[string]chan struct{})
+	for _, task := range prog.tasks {
+		if task.taskType == TaskNamed {
+			interp.taskDone[task.name] = make(chan struct{})
+		}
+	}
+	interp.initHandlers(prog)
+	interp.initSetQueues()
 
 	interp.initValues(varInits)
 }
 
+// LoadStaticValues adds read-only values for OIDs which are not program variables,
+// e.g. from a device dump. OIDs declared by the program take precedence.
+func (interp *Interpreter) LoadStaticValues(vals map[string]*Value) {
+	for oidStr, val := range vals {
+		interp.AddStaticValue(oidStr, val, SnmpModeRead)
+	}
+}
+
+// AddStaticValue adds a value for an OID which is not a program variable
+// OIDs declared by the program take precedence.
+func (interp *Interpreter) AddStaticValue(oidStr string, val *Value, snmpMode SnmpMode) {
+	interp.valLock.Lock()
+	defer interp.valLock.Unlock()
+
+	if _, ok := interp.variables.typesFromOid[oidStr]; ok {
+		return
+	}
+	interp.oid2Values[oidStr] = val
+	interp.staticModes[oidStr] = snmpMode
+}
+
+// AddLiveValue adds a read-only OID whose value is computed each time it is read
+// The function is called with the lock held.
+func (interp *Interpreter) AddLiveValue(oidStr string, valueType ValueType, liveFunc func() *Value) {
+	interp.valLock.Lock()
+	defer interp.valLock.Unlock()
+
+	if _, ok := interp.variables.typesFromOid[oidStr]; ok {
+		return
+	}
+	interp.oid2Values[oidStr] = &Value{valueType: valueType}
+	interp.staticModes[oidStr] = SnmpModeRead
+	interp.liveValues[oidStr] = liveFunc
+}
+
+// SetStaticValue is a thread safe version of setting value of a non program OID
+func (interp *Interpreter) SetStaticValue(oidStr string, val *Value) {
+	interp.valLock.Lock()
+	defer interp.valLock.Unlock()
+
+	interp.oid2Values[oidStr] = val
+}
+
 func isValidOID(str string) (err error) {
 	fields := strings.Split(str, ".")
 	for _, x := range fields {
@@ -208,6 +373,48 @@ func isValidOID(str string) (err error) {
 	return nil
 }
 
+// compareAddrs orders IPv4 addresses numerically
+// returns -1, 0 or 1 like strings.Compare
+func compareAddrs(addr1 string, addr2 string) int {
+	return bytes.Compare(net.ParseIP(addr1).To4(), net.ParseIP(addr2).To4())
+}
+
+// compareOids orders OIDs component by component
+// returns -1, 0 or 1 like strings.Compare
+func compareOids(oid1 string, oid2 string) int {
+	fields1 := strings.Split(strings.TrimPrefix(oid1, "."), ".")
+	fields2 := strings.Split(strings.TrimPrefix(oid2, "."), ".")
+	for i := 0; i < len(fields1) && i < len(fields2); i++ {
+		x1, _ := strconv.ParseUint(fields1[i], 10, 32)
+		x2, _ := strconv.ParseUint(fields2[i], 10, 32)
+		if x1 < x2 {
+			return -1
+		}
+		if x1 > x2 {
+			return 1
+		}
+	}
+	switch {
+	case len(fields1) < len(fields2):
+		return -1
+	case len(fields1) > len(fields2):
+		return 1
+	}
+	return 0
+}
+
+// sortedOids returns the OIDs of the map in MIB order
+func sortedOids(vals map[string]*Value) []string {
+	oids := make([]string, 0, len(vals))
+	for oidStr := range vals {
+		oids = append(oids, oidStr)
+	}
+	sort.Slice(oids, func(i, j int) bool {
+		return compareOids(oids[i], oids[j]) < 0
+	})
+	return oids
+}
+
 func isValidIpv4Address(str string) (err error) {
 	fields := strings.Split(str, ".")
 
@@ -226,16 +433,158 @@ func isValidIpv4Address(str string) (err error) {
 
 // InterpProgram Interprets the program aka runs the program
 // prog - the program parse tree to run
+// The tasks and handlers run alongside the run block and are stopped when it ends.
+// An error in any of them stops the program.
 func (interp *Interpreter) InterpProgram(prog *Program) (err error) {
+	var wg sync.WaitGroup
+	taskErrs := make(chan error, len(prog.tasks)+len(prog.handlers))
+	for _, task := range prog.tasks {
+		task := task
+		interp.background(&wg, taskErrs, task, interp.taskDone[task.name], func(taskInterp *Interpreter) error {
+			return taskInterp.runTask(task)
+		})
+	}
+	for _, handler := range prog.handlers {
+		if handler.handlerType != HandlerSet {
+			continue // run by the SNMP server
+		}
+		handler := handler
+		interp.background(&wg, taskErrs, handler, nil, func(handlerInterp *Interpreter) error {
+			return handlerInterp.runHandler(handler)
+		})
+	}
+
 	_, err = interp.interpStatementList(prog.stmtList)
-	if err != nil {
-		return err
+	interp.stopProgram()
+	wg.Wait()
+
+	if err == nil || err == errStopped {
+		select {
+		case err = <-taskErrs:
+		default:
+			err = nil
+		}
+	}
+	return err
+}
+
+// background runs statements in a goroutine of their own, closing any done channel when they end
+// An error stops the program.
+func (interp *Interpreter) background(wg *sync.WaitGroup, errs chan<- error, name fmt.Stringer, done chan struct{},
+	run func(*Interpreter) error) {
+
+	wg.Add(1)
+	go func(forked *Interpreter) {
+		defer wg.Done()
+		if done != nil {
+			defer close(done)
+		}
+		err := run(forked)
+		if err != nil && err != errStopped {
+			errs <- fmt.Errorf("%v: %v", name, err)
+			interp.stopProgram()
+		}
+	}(interp.fork())
+}
+
+// runTask runs the task's statements when they are scheduled
+func (interp *Interpreter) runTask(task *Task) (err error) {
+	switch task.taskType {
+	case TaskAfter:
+		delay, err := interp.interpDuration(task.interval, task.units)
+		if err != nil {
+			return err
+		}
+		err = interp.sleep(delay)
+		if err != nil {
+			return err
+		}
+	case TaskEvery:
+		next := time.Now()
+		for {
+			interval, err := interp.interpDuration(task.interval, task.units)
+			if err != nil {
+				return err
+			}
+			if interval <= 0 {
+				return fmt.Errorf("Line %d: Interval of every block is not positive", task.lineNum)
+			}
+			// keep to the interval but don't try to catch up on missed runs
+			next = next.Add(interval)
+			if now := time.Now(); next.Before(now) {
+				next = now
+			}
+			err = interp.sleep(time.Until(next))
+			if err != nil {
+				return err
+			}
+			exit, err := interp.interpStatementList(task.stmtList)
+			if err != nil || exit {
+				return err
+			}
+		}
+	case TaskAt:
+		for {
+			err = interp.sleep(time.Until(nextTimeOfDay(time.Now(), task)))
+			if err != nil {
+				return err
+			}
+			exit, err := interp.interpStatementList(task.stmtList)
+			if err != nil || exit {
+				return err
+			}
+		}
+	}
+	_, err = interp.interpStatementList(task.stmtList)
+	return err
+}
+
+// nextTimeOfDay gets when an at block is next due after now
+func nextTimeOfDay(now time.Time, task *Task) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), task.atHour, task.atMinute, task.atSecond, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, task.atHour, task.atMinute, task.atSecond, 0, now.Location())
+	}
+	return next
+}
+
+// fork gets an interpreter for another goroutine sharing the variables
+func (interp *Interpreter) fork() *Interpreter {
+	forked := *interp
+	forked.frame = nil
+	return &forked
+}
+
+func (interp *Interpreter) stopProgram() {
+	interp.stopOnce.Do(func() { close(interp.stop) })
+}
+
+func (interp *Interpreter) stopped() bool {
+	select {
+	case <-interp.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep for the duration unless the program is stopped first
+func (interp *Interpreter) sleep(duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-interp.stop:
+		return errStopped
 	}
-	return nil
 }
 
 func (interp *Interpreter) interpStatementList(stmtList []*Statement) (isExit bool, err error) {
 	for _, stmt := range stmtList {
+		if interp.stopped() {
+			return false, errStopped
+		}
 		exit, err := interp.interpStatement(stmt)
 		if err != nil {
 			return false, err
@@ -243,6 +592,10 @@ func (interp *Interpreter) interpStatementList(stmtList []*Statement) (isExit bo
 		if exit {
 			return true, nil
 		}
+		if interp.frame != nil && interp.frame.returned {
+			// leave any enclosing loops too
+			return true, nil
+		}
 	}
 	return false, nil
 }
@@ -263,6 +616,19 @@ func (interp *Interpreter) interpStatement(stmt *Statement) (isExit bool, err er
 		err = interp.interpSleepStmt(stmt.sleepStmt)
 	case StmtRead:
 		err = interp.interpReadStmt(stmt.readStmt)
+	case StmtDump:
+		err = interp.interpDumpStmt(stmt.dumpStmt)
+	case StmtCall:
+		_, err = interp.interpCall(stmt.callStmt.call)
+	case StmtReturn:
+		err = interp.interpReturnStmt(stmt.returnStmt)
+		isExit = true
+	case StmtWait:
+		err = interp.interpWaitStmt(stmt.waitStmt)
+	case StmtSelect:
+		isExit, err = interp.interpSelectStmt(stmt.selectStmt)
+	case StmtFor:
+		err = interp.interpForStmt(stmt.forStmt)
 	case StmtBreak:
 		return true, nil
 	}
@@ -295,32 +661,285 @@ func (interp *Interpreter) interpPrintStmt(printStmt *PrintStatement) (err error
 	if err != nil {
 		return err
 	}
-	fmt.Println(val) // TODO: handle backslash characters
+	fmt.Println(val)
 	return nil
 }
 
+// interpReadStmt waits for a manager to set the variable
 func (interp *Interpreter) interpReadStmt(readStmt *ReadStatement) (err error) {
-	typ := interp.variables.types[readStmt.identifier]
-	value := <-typ.externalValue
+	expired, cancel, err := interp.startTimeout(readStmt.timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
 
-	interp.SetValueForIdOid(readStmt.identifier, typ.oid, value)
+	chosen, err := interp.readExternal([]string{readStmt.identifier}, expired)
+	if err != nil {
+		return err
+	}
+	interp.setTimedOut(readStmt.timeout, chosen < 0)
 	return nil
 }
 
+// interpSelectStmt runs the case of whichever variable a manager sets first
+func (interp *Interpreter) interpSelectStmt(selectStmt *SelectStatement) (isExit bool, err error) {
+	expired, cancel, err := interp.startTimeout(selectStmt.timeout)
+	if err != nil {
+		return false, err
+	}
+	defer cancel()
+
+	ids := make([]string, len(selectStmt.cases))
+	for i, selectCase := range selectStmt.cases {
+		ids[i] = selectCase.identifier
+	}
+	chosen, err := interp.readExternal(ids, expired)
+	if err != nil {
+		return false, err
+	}
+	interp.setTimedOut(selectStmt.timeout, chosen < 0)
+	if chosen < 0 {
+		return interp.interpStatementList(selectStmt.timeoutStmtList)
+	}
+	return interp.interpStatementList(selectStmt.cases[chosen].stmtList)
+}
+
+// readExternal waits for a manager to set one of the variables
+// returning the index of the variable set or -1 if the timeout expired first.
+// A rw variable already has the value when it is received
+// but a rwb one is set from the oldest value in its queue.
+func (interp *Interpreter) readExternal(ids []string, expired <-chan time.Time) (chosen int, err error) {
+	cases := make([]reflect.SelectCase, 0, len(ids)+2)
+	for _, id := range ids {
+		typ := interp.variables.types[id]
+		ch := reflect.ValueOf(typ.externalValue)
+		if typ.snmpMode == SnmpModeReadWriteBlocked {
+			ch = reflect.ValueOf(interp.setQueues[id].ready)
+		}
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: ch})
+	}
+	cases = append(cases,
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(interp.stop)},
+		reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(expired)})
+
+	for {
+		// values already queued are read without waiting
+		for i, id := range ids {
+			queue, ok := interp.setQueues[id]
+			if !ok {
+				continue
+			}
+			if val, ok := queue.pop(); ok {
+				typ := interp.variables.types[id]
+				interp.SetValueForIdOid(id, typ.oid, val)
+				return i, nil
+			}
+		}
+
+		chosen, _, _ = reflect.Select(cases)
+		switch {
+		case chosen == len(ids):
+			return 0, errStopped
+		case chosen == len(ids)+1:
+			return -1, nil
+		case interp.variables.types[ids[chosen]].snmpMode == SnmpModeReadWrite:
+			return chosen, nil
+		}
+		// a queue had a value added which is taken above unless another read got it first
+	}
+}
+
+func (interp *Interpreter) interpDumpStmt(dumpStmt *DumpStatement) (err error) {
+	filename, err := interp.interpStringExpression(dumpStmt.filename)
+	if err != nil {
+		return err
+	}
+	return writeWalkFile(filename, interp)
+}
+
+// interpCall runs a procedure or function with its arguments in a new frame
+// returning the function's value
+func (interp *Interpreter) interpCall(call *Call) (val *Value, err error) {
+	depth := 1
+	if interp.frame != nil {
+		depth = interp.frame.depth + 1
+	}
+	if depth > maxCallDepth {
+		return nil, fmt.Errorf("Line %d: Call depth of %d exceeded calling %s", call.lineNum, maxCallDepth, call.name)
+	}
+
+	// arguments are worked out in the caller's frame
+	frame := &Frame{types: make(map[string]*Type), values: make(map[string]*Value), depth: depth}
+	for i, param := range call.proc.params {
+		val, err := interp.interpExpression(call.args[i])
+		if err != nil {
+			return nil, err
+		}
+		val.valueType = param.valueType // e.g. counter from integer expression
+		frame.types[param.id] = param
+		frame.values[param.id] = val
+	}
+
+	callerFrame := interp.frame
+	interp.frame = frame
+	defer func() { interp.frame = callerFrame }()
+
+	_, err = interp.interpStatementList(call.proc.stmtList)
+	if err != nil {
+		return nil, err
+	}
+	if call.proc.returnType == ValueNone {
+		return nil, nil
+	}
+	if !frame.returned {
+		return nil, fmt.Errorf("Line %d: Function %s ended without returning a value", call.lineNum, call.name)
+	}
+	val = frame.returnValue
+	val.valueType = call.proc.returnType
+	return val, nil
+}
+
+func (interp *Interpreter) interpReturnStmt(returnStmt *ReturnStatement) (err error) {
+	if returnStmt.exprn != nil {
+		interp.frame.returnValue, err = interp.interpExpression(returnStmt.exprn)
+		if err != nil {
+			return err
+		}
+	}
+	interp.frame.returned = true
+	return nil
+}
+
+// lookupVarType gets the type of a parameter or global variable
+func (interp *Interpreter) lookupVarType(id string) *Type {
+	if interp.frame != nil {
+		if typ, ok := interp.frame.types[id]; ok {
+			return typ
+		}
+	}
+	return interp.variables.types[id]
+}
+
+// setVariable sets a parameter or global variable
+func (interp *Interpreter) setVariable(id string, typ *Type, val *Value) {
+	if interp.frame != nil {
+		if _, ok := interp.frame.types[id]; ok {
+			interp.frame.values[id] = val
+			return
+		}
+	}
+	interp.SetValueForIdOid(id, typ.oid, val)
+}
+
 func (interp *Interpreter) interpSleepStmt(sleepStmt *SleepStatement) (err error) {
-	duration, err := interp.interpIntExpression(sleepStmt.exprn)
+	duration, err := interp.interpDuration(sleepStmt.exprn, sleepStmt.units)
 	if err != nil {
 		return err
 	}
-	switch sleepStmt.units {
-	case TimeSecs:
-		time.Sleep(time.Duration(duration) * time.Second)
+	return interp.sleep(duration)
+}
+
+func (interp *Interpreter) interpDuration(exprn *IntExpression, units TimeUnit) (time.Duration, error) {
+	x, err := interp.interpIntExpression(exprn)
+	if err != nil {
+		return 0, err
+	}
+	switch units {
 	case TimeMillis:
-		time.Sleep(time.Duration(duration) * time.Millisecond)
+		return time.Duration(x) * time.Millisecond, nil
+	default:
+		return time.Duration(x) * time.Second, nil
 	}
+}
+
+// interpWaitStmt waits for the task to end, the variable to be polled or the condition to hold
+func (interp *Interpreter) interpWaitStmt(waitStmt *WaitStatement) (err error) {
+	expired, cancel, err := interp.startTimeout(waitStmt.timeout)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	timedOut := false
+	switch waitStmt.waitType {
+	case WaitTask:
+		select {
+		case <-interp.taskDone[waitStmt.taskName]:
+		case <-expired:
+			timedOut = true
+		case <-interp.stop:
+			return errStopped
+		}
+	case WaitPoll:
+		times := 1
+		if waitStmt.times != nil {
+			times, err = interp.interpIntExpression(waitStmt.times)
+			if err != nil {
+				return err
+			}
+		}
+		typ := interp.variables.types[waitStmt.identifier]
+		timedOut, err = interp.polls.wait(typ.oid, times, interp.stop, expired)
+		if err != nil {
+			return err
+		}
+	case WaitUntil:
+		timedOut, err = interp.waitUntil(waitStmt.condition, expired)
+		if err != nil {
+			return err
+		}
+	}
+	interp.setTimedOut(waitStmt.timeout, timedOut)
 	return nil
 }
 
+// waitUntil works out the condition again each time a value changes until it holds
+// returning whether it timed out first
+func (interp *Interpreter) waitUntil(condition *BoolExpression, expired <-chan time.Time) (timedOut bool, err error) {
+	for {
+		// get the channel first so a change while working it out isn't missed
+		changed := interp.changes.next()
+		holds, err := interp.interpBoolExpression(condition)
+		if err != nil {
+			return false, err
+		}
+		if holds {
+			return false, nil
+		}
+
+		select {
+		case <-changed:
+		case <-expired:
+			return true, nil
+		case <-interp.stop:
+			return false, errStopped
+		}
+	}
+}
+
+// startTimeout starts any timeout of a blocking statement
+// The channel is nil, so never ready, when there is no timeout.
+func (interp *Interpreter) startTimeout(timeout *Timeout) (expired <-chan time.Time, cancel func(), err error) {
+	if timeout == nil {
+		return nil, func() {}, nil
+	}
+	duration, err := interp.interpDuration(timeout.exprn, timeout.units)
+	if err != nil {
+		return nil, nil, err
+	}
+	timer := time.NewTimer(duration)
+	return timer.C, func() { timer.Stop() }, nil
+}
+
+// setTimedOut sets any variable for whether the statement timed out
+func (interp *Interpreter) setTimedOut(timeout *Timeout, timedOut bool) {
+	if timeout == nil || timeout.timedOutId == "" {
+		return
+	}
+	id := timeout.timedOutId
+	interp.setVariable(id, interp.lookupVarType(id), &Value{valueType: ValueBoolean, boolVal: timedOut})
+}
+
 func (interp *Interpreter) interpLoopStmt(loopStmt *LoopStatement) (err error) {
 	switch loopStmt.loopType {
 	case LoopForever:
@@ -368,6 +987,26 @@ func (interp *Interpreter) interpLoopStmt(loopStmt *LoopStatement) (err error) {
 	return nil
 }
 
+// interpForStmt sets the variable to each bit of the bitset as it was at the start
+func (interp *Interpreter) interpForStmt(forStmt *ForStatement) (err error) {
+	bitset, err := interp.interpBitsetExpression(forStmt.bitsetExpression)
+	if err != nil {
+		return err
+	}
+	typ := interp.lookupVarType(forStmt.identifier)
+	for _, bit := range bitset.bits() {
+		interp.setVariable(forStmt.identifier, typ, intValue(bit))
+		exit, err := interp.interpStatementList(forStmt.stmtList)
+		if err != nil {
+			return err
+		}
+		if exit {
+			break
+		}
+	}
+	return nil
+}
+
 func updateBytesValueField(uintValue uint, fieldId string, vals BytesMap, sizes map[string]uint) (err error) {
 
 	size := sizes[fieldId] // how many bytes
@@ -384,7 +1023,7 @@ func (interp *Interpreter) interpAssignmentStmt(assign *AssignmentStatement) (er
 	if err != nil {
 		return err
 	}
-	varType := interp.variables.types[assign.identifier]
+	varType := interp.lookupVarType(assign.identifier)
 
 	// ensure counter/timeticks/guage overrides integer type expression
 	if varType.valueType == ValueCounter || varType.valueType == ValueTimeticks ||
@@ -394,20 +1033,22 @@ func (interp *Interpreter) interpAssignmentStmt(assign *AssignmentStatement) (er
 
 	// field assignment - modify part of the value
 	if varType.valueType == ValueBytes && value.valueType == ValueInteger && len(assign.fieldId) > 0 {
+		// copy the fields so the current value is never modified outside the lock
 		lhsValue, _ := interp.GetValueForId(assign.identifier)
-		if lhsValue.bytesVal == nil {
-			lhsValue.bytesVal = make(BytesMap)
+		bytesVal := make(BytesMap)
+		for k, v := range lhsValue.bytesVal {
+			bytesVal[k] = v
 		}
-		err := updateBytesValueField(uint(value.intVal), assign.fieldId, lhsValue.bytesVal, varType.fieldInfo.fieldSizes)
+		err := updateBytesValueField(uint(value.intVal), assign.fieldId, bytesVal, varType.fieldInfo.fieldSizes)
 		if err != nil {
 			return err
 		}
 		value = new(Value)
 		value.valueType = ValueBytes
-		value.bytesVal = lhsValue.bytesVal
+		value.bytesVal = bytesVal
 	}
 
-	interp.SetValueForIdOid(assign.identifier, varType.oid, value)
+	interp.setVariable(assign.identifier, varType, value)
 	//fmt.Printf("setvalue: %s %v\n", typ.oid, value)
 	return nil
 }
@@ -437,6 +1078,8 @@ func (interp *Interpreter) interpExpression(exprn *Expression) (val *Value, err
 	case ExprnAddr:
 		val.valueType = ValueIpv4address
 		val.addrVal, err = interp.interpAddrExpression(exprn.addrExpression)
+	case ExprnConditional:
+		val, err = interp.interpConditional(exprn.conditional)
 	}
 	if err != nil {
 		return nil, err
@@ -483,6 +1126,43 @@ func (interp *Interpreter) interpBitsetTerm(term *BitsetTerm) (val BitsetMap, er
 		return val.bitsetVal, nil
 	case BitsetTermBracket:
 		return interp.interpBitsetExpression(term.bracketedExprn)
+	case BitsetTermCall:
+		val, err := interp.interpCall(term.call)
+		if err != nil {
+			return nil, err
+		}
+		return val.bitsetVal, nil
+	case BitsetTermBuiltin:
+		val, err := interp.interpBuiltinCall(term.builtin)
+		if err != nil {
+			return nil, err
+		}
+		return val.bitsetVal, nil
+	case BitsetTermConditional:
+		val, err := interp.interpConditional(term.conditional)
+		if err != nil {
+			return nil, err
+		}
+		return val.bitsetVal, nil
+	case BitsetTermIntersection:
+		val, err = interp.interpBitsetTerm(term.intersectTerms[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, intersectTerm := range term.intersectTerms[1:] {
+			rhs, err := interp.interpBitsetTerm(intersectTerm)
+			if err != nil {
+				return nil, err
+			}
+			product := make(BitsetMap)
+			for k, set := range val {
+				if set && rhs[k] {
+					product[k] = true
+				}
+			}
+			val = product
+		}
+		return val, nil
 	}
 	return nil, fmt.Errorf("Invalid bitset type: %d", term.bitsetTermType)
 }
@@ -536,6 +1216,24 @@ func (interp *Interpreter) interpOidTerm(oidTerm *OidTerm) (string, error) {
 	case OidTermId:
 		val, _ := interp.GetValueForId(oidTerm.identifier)
 		return val.oidVal, nil
+	case OidTermCall:
+		val, err := interp.interpCall(oidTerm.call)
+		if err != nil {
+			return "", err
+		}
+		return val.oidVal, nil
+	case OidTermBuiltin:
+		val, err := interp.interpBuiltinCall(oidTerm.builtin)
+		if err != nil {
+			return "", err
+		}
+		return val.oidVal, nil
+	case OidTermConditional:
+		val, err := interp.interpConditional(oidTerm.conditional)
+		if err != nil {
+			return "", err
+		}
+		return val.oidVal, nil
 	}
 	return "", nil
 }
@@ -547,6 +1245,24 @@ func (interp *Interpreter) interpAddrExpression(addrExprn *AddrExpression) (stri
 	case AddrExprnId:
 		val, _ := interp.GetValueForId(addrExprn.identifier)
 		return val.addrVal, nil
+	case AddrExprnCall:
+		val, err := interp.interpCall(addrExprn.call)
+		if err != nil {
+			return "", err
+		}
+		return val.addrVal, nil
+	case AddrExprnBuiltin:
+		val, err := interp.interpBuiltinCall(addrExprn.builtin)
+		if err != nil {
+			return "", err
+		}
+		return val.addrVal, nil
+	case AddrExprnConditional:
+		val, err := interp.interpConditional(addrExprn.conditional)
+		if err != nil {
+			return "", err
+		}
+		return val.addrVal, nil
 	}
 	return "", nil
 }
@@ -560,6 +1276,15 @@ func (interp *Interpreter) interpStringTerm(strTerm *StringTerm) (string, error)
 	case StringTermId:
 		val, _ := interp.GetValueForId(strTerm.identifier)
 		return val.stringVal, nil
+	case StringTermStringedId:
+		val, _ := interp.GetValueForId(strTerm.identifier)
+		return val.text(), nil
+	case StringTermConditional:
+		val, err := interp.interpConditional(strTerm.conditional)
+		if err != nil {
+			return "", err
+		}
+		return val.stringVal, nil
 	case StringTermStringedBoolExprn:
 		b, err := interp.interpBoolExpression(strTerm.stringedBoolExprn)
 		if err != nil {
@@ -596,6 +1321,18 @@ func (interp *Interpreter) interpStringTerm(strTerm *StringTerm) (string, error)
 			return "", err
 		}
 		return b.String(), nil
+	case StringTermCall:
+		val, err := interp.interpCall(strTerm.call)
+		if err != nil {
+			return "", err
+		}
+		return val.stringVal, nil
+	case StringTermBuiltin:
+		val, err := interp.interpBuiltinCall(strTerm.builtin)
+		if err != nil {
+			return "", err
+		}
+		return val.stringVal, nil
 	}
 	return "", nil
 }
@@ -642,10 +1379,120 @@ func (interp *Interpreter) interpBoolFactor(boolFactor *BoolFactor) (val bool, e
 		return interp.interpIntComparison(boolFactor.intComparison)
 	case BoolFactorContains:
 		return interp.interpContains(boolFactor.bitsetId, boolFactor.bitsetElement)
+	case BoolFactorCall:
+		value, err := interp.interpCall(boolFactor.call)
+		if err != nil {
+			return false, err
+		}
+		return value.boolVal, nil
+	case BoolFactorBuiltin:
+		value, err := interp.interpBuiltinCall(boolFactor.builtin)
+		if err != nil {
+			return false, err
+		}
+		return value.boolVal, nil
+	case BoolFactorConditional:
+		value, err := interp.interpConditional(boolFactor.conditional)
+		if err != nil {
+			return false, err
+		}
+		return value.boolVal, nil
+	case BoolFactorComparison:
+		return interp.interpComparison(boolFactor.comparison)
+	case BoolFactorStrContains:
+		container, err := interp.interpStringExpression(boolFactor.strContainer)
+		if err != nil {
+			return false, err
+		}
+		element, err := interp.interpStringExpression(boolFactor.strElement)
+		if err != nil {
+			return false, err
+		}
+		return strings.Contains(container, element), nil
 	}
 	return false, nil
 }
 
+func (interp *Interpreter) interpComparison(comparison *Comparison) (bool, error) {
+	lhs, err := interp.interpExpression(comparison.lhs)
+	if err != nil {
+		return false, err
+	}
+	rhs, err := interp.interpExpression(comparison.rhs)
+	if err != nil {
+		return false, err
+	}
+
+	var order int
+	switch comparison.valueType {
+	case ValueString:
+		order = strings.Compare(lhs.stringVal, rhs.stringVal)
+	case ValueOid:
+		order = compareOids(lhs.oidVal, rhs.oidVal)
+	case ValueIpv4address:
+		order = compareAddrs(lhs.addrVal, rhs.addrVal)
+	case ValueBoolean:
+		if comparison.comparator == IntCompNotEquals {
+			return lhs.boolVal != rhs.boolVal, nil
+		}
+		return lhs.boolVal == rhs.boolVal, nil
+	case ValueBitset:
+		return compareBitsets(comparison.comparator, lhs.bitsetVal, rhs.bitsetVal), nil
+	}
+	return compareOrder(comparison.comparator, order), nil
+}
+
+// compareOrder gets the result of the comparison given the order of its sides like strings.Compare
+func compareOrder(comparator IntComparatorType, order int) bool {
+	switch comparator {
+	case IntCompEquals:
+		return order == 0
+	case IntCompNotEquals:
+		return order != 0
+	case IntCompGreaterEquals:
+		return order >= 0
+	case IntCompGreaterThan:
+		return order > 0
+	case IntCompLessEquals:
+		return order <= 0
+	case IntCompLessThan:
+		return order < 0
+	}
+	return false
+}
+
+// compareBitsets compares the bitsets by equality and subset
+// so "<=" is a subset and "<" a proper subset.
+func compareBitsets(comparator IntComparatorType, lhs BitsetMap, rhs BitsetMap) bool {
+	lhsInRhs := isSubset(lhs, rhs)
+	rhsInLhs := isSubset(rhs, lhs)
+	switch comparator {
+	case IntCompEquals:
+		return lhsInRhs && rhsInLhs
+	case IntCompNotEquals:
+		return !(lhsInRhs && rhsInLhs)
+	case IntCompLessEquals:
+		return lhsInRhs
+	case IntCompLessThan:
+		return lhsInRhs && !rhsInLhs
+	case IntCompGreaterEquals:
+		return rhsInLhs
+	case IntCompGreaterThan:
+		return rhsInLhs && !lhsInRhs
+	}
+	return false
+}
+
+// isSubset reports whether every bit set in sub is set in set
+func isSubset(sub BitsetMap, set BitsetMap) bool {
+	for bit, on := range sub {
+		if on && !set[bit] {
+			return false
+		}
+	}
+	return true
+}
+
 func (interp *Interpreter) interpContains(bitsetId string, bitsetElement *IntExpression) (bRet bool, err error) {
 	i, err := interp.interpIntExpression(bitsetElement)
 	if err != nil {
@@ -670,6 +1517,8 @@ func (interp *Interpreter) interpIntComparison(intComparison *IntComparison) (bo
 	switch intComparison.intComparator {
 	case IntCompEquals:
 		return lhs == rhs, nil
+	case IntCompNotEquals:
+		return lhs != rhs, nil
 	case IntCompGreaterEquals:
 		return lhs >= rhs, nil
 	case IntCompGreaterThan:
@@ -683,6 +1532,9 @@ func (interp *Interpreter) interpIntComparison(intComparison *IntComparison) (bo
 }
 
 func (interp *Interpreter) interpIntExpression(intExpression *IntExpression) (int, error) {
+	if intExpression.intOp != IntOpNone {
+		return interp.interpIntOperation(intExpression)
+	}
 	val := 0
 	for _, term := range intExpression.plusTerms {
 		plusVal, err := interp.interpIntTerm(term)
@@ -701,6 +1553,34 @@ func (interp *Interpreter) interpIntExpression(intExpression *IntExpression) (in
 	return val, nil
 }
 
+func (interp *Interpreter) interpIntOperation(intExpression *IntExpression) (int, error) {
+	lhs, err := interp.interpIntExpression(intExpression.lhs)
+	if err != nil {
+		return 0, err
+	}
+	rhs, err := interp.interpIntExpression(intExpression.rhs)
+	if err != nil {
+		return 0, err
+	}
+	switch intExpression.intOp {
+	case IntOpBitOr:
+		return lhs | rhs, nil
+	case IntOpBitXor:
+		return lhs ^ rhs, nil
+	case IntOpBitAnd:
+		return lhs & rhs, nil
+	case IntOpShiftLeft, IntOpShiftRight:
+		if rhs < 0 {
+			return 0, fmt.Errorf("Line %d: Negative shift count %d", intExpression.lineNum, rhs)
+		}
+		if intExpression.intOp == IntOpShiftLeft {
+			return lhs << uint(rhs), nil
+		}
+		return lhs >> uint(rhs), nil
+	}
+	return 0, fmt.Errorf("Invalid integer operator: %d", intExpression.intOp)
+}
+
 func (interp *Interpreter) interpIntTerm(intTerm *IntTerm) (int, error) {
 	val := 1
 	for _, factor := range intTerm.timesFactors {
@@ -715,8 +1595,21 @@ func (interp *Interpreter) interpIntTerm(intTerm *IntTerm) (int, error) {
 		if err != nil {
 			return 1, err
 		}
+		if divideVal == 0 {
+			return 1, fmt.Errorf("Line %d: Division by zero", intTerm.lineNum)
+		}
 		val /= divideVal
 	}
+	for _, factor := range intTerm.modFactors {
+		modVal, err := interp.interpIntFactor(factor)
+		if err != nil {
+			return 1, err
+		}
+		if modVal == 0 {
+			return 1, fmt.Errorf("Line %d: Modulo by zero", intTerm.lineNum)
+		}
+		val %= modVal
+	}
 	return val, nil
 }
 
@@ -735,6 +1628,34 @@ func (interp *Interpreter) interpIntFactor(intFactor *IntFactor) (int, error) {
 			return 0, err
 		}
 		return -value, nil
+	case IntFactorBitNot:
+		value, err := interp.interpIntFactor(intFactor.bitNotFactor)
+		if err != nil {
+			return 0, err
+		}
+		return ^value, nil
+	case IntFactorConditional:
+		value, err := interp.interpConditional(intFactor.conditional)
+		if err != nil {
+			return 0, err
+		}
+		return value.intVal, nil
+	case IntFactorCall:
+		value, err := interp.interpCall(intFactor.call)
+		if err != nil {
+			return 0, err
+		}
+		return value.intVal, nil
+	case IntFactorPolls:
+		return interp.pollCount(intFactor.intIdentifier), nil
+	case IntFactorQueued:
+		return interp.queuedCount(intFactor.intIdentifier), nil
+	case IntFactorBuiltin:
+		value, err := interp.interpBuiltinCall(intFactor.builtin)
+		if err != nil {
+			return 0, err
+		}
+		return value.intVal, nil
 	}
 	return 0, nil
 }
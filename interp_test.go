@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"time"
 )
 
 func runProgram(progStr string) {
@@ -25,6 +27,17 @@ func runProgram(progStr string) {
 	}
 }
 
+// parse a program returning an interpreter set up to run it
+func initTestProgram(progStr string) (*Interpreter, *Program, error) {
+	program, err := NewParser(lex("test", progStr)).ParseProgram()
+	if err != nil {
+		return nil, nil, err
+	}
+	interp := new(Interpreter)
+	interp.Init(program, make(map[string]string))
+	return interp, program, nil
+}
+
 func ExampleInterp1() {
 	prog := `
   var
@@ -157,3 +170,707 @@ endrun`
 	// large
 	// 15
 }
+
+// run a program printing any parsing or interpreting error
+func runProgramPrintError(progStr string) {
+	interp, program, err := initTestProgram(progStr)
+	if err != nil {
+		fmt.Printf("Parsing error: %s\n", err)
+		return
+	}
+	err = interp.InterpProgram(program)
+	if err != nil {
+		fmt.Printf("Interpreting error: %s\n", err)
+	}
+}
+
+func ExampleInterp5() {
+	prog := `
+var
+  marker-count: 2.1.43.10.2.1.4.1.1 counter
+  n: integer
+endvar
+proc printPage(n: integer, color: boolean, name: string)
+  marker-count = marker-count + 1
+  n = n * 2
+  if color
+    print name + " color page " + strInt(marker-count) + " " + strInt(n)
+  else
+    print name + " mono page " + strInt(marker-count) + " " + strInt(n)
+  endif
+endproc
+proc countdown(n: integer)
+  if n > 0
+    print strInt(n)
+    call countdown(n - 1)
+  endif
+endproc
+run
+  n = 7
+  call printPage(1, true, "first")
+  call printPage(n, false, "second")
+  print "n is still " + strInt(n)
+  call countdown(3)
+endrun`
+	runProgramPrintError(prog)
+	// Output:
+	// first color page 1 2
+	// second mono page 2 14
+	// n is still 7
+	// 3
+	// 2
+	// 1
+}
+
+func ExampleInterp6() {
+	runProgramPrintError(`
+var
+  i: integer
+endvar
+proc forever()
+  i = i + 1
+  call forever()
+endproc
+run
+  call forever()
+endrun`)
+	runProgramPrintError(`
+run
+  call missing()
+endrun`)
+	runProgramPrintError(`
+proc twoArgs(a: integer, b: string)
+endproc
+run
+  call twoArgs(1)
+endrun`)
+	runProgramPrintError(`
+proc twoArgs(a: integer, a: string)
+endproc
+run
+endrun`)
+	// Output:
+	// Interpreting error: Line 7: Call depth of 1000 exceeded calling forever
+	// Parsing error: test: Error at line 3: Call of undefined procedure: missing
+	// Parsing error: test: Error at line 5: Wrong number of arguments calling twoArgs (expecting 2)
+	// Parsing error: test: Error at line 2: Duplicate parameter: a
+}
+
+func ExampleInterp7() {
+	prog := `
+var
+  status: integer [1 = 'idle', 2 = 'printing', 3 = 'stopped']
+  error-state: bitset [0 = 'low paper', 1 = 'no paper']
+  name: string
+  base: oid
+endvar
+func nextStatus(s: integer): integer
+  if s = 'stopped'
+    return 'idle'
+  endif
+  return s + 1
+endfunc
+func fact(n: integer): integer
+  if n <= 1
+    return 1
+  endif
+  return n * fact(n - 1)
+endfunc
+func label(s: integer, verbose: boolean): string
+  loop
+    if verbose
+      return "status " + strInt(s)
+    endif
+    break
+  endloop
+  return strInt(s)
+endfunc
+func isBusy(s: integer): boolean
+  return s = 'printing'
+endfunc
+func entry(column: oid): oid
+  return base + .1 + column
+endfunc
+func paperErrors(): bitset
+  return ['low paper', 'no paper']
+endfunc
+run
+  base = .1.3.6.1.2.1.43
+  status = 'idle'
+  loop times 3
+    status = nextStatus(status)
+    print label(status, isBusy(status)) + " busy " + strBool(isBusy(status))
+  endloop
+  print strInt(fact(5) + 1)
+  print strOid(entry(.6))
+  error-state = paperErrors() - ['low paper']
+  print strBitset(error-state)
+endrun`
+	runProgramPrintError(prog)
+	// Output:
+	// status 2 busy true
+	// 3 busy false
+	// 1 busy false
+	// 121
+	// .1.3.6.1.2.1.43.1.6
+	// {1}
+}
+
+func ExampleInterp8() {
+	runProgramPrintError(`
+var
+  i: integer
+endvar
+func bump(): integer
+  i = i + 1
+  return i
+endfunc
+run
+endrun`)
+	runProgramPrintError(`
+func nap(): integer
+  sleep 1 secs
+  return 0
+endfunc
+run
+endrun`)
+	runProgramPrintError(`
+func noReturn(a: integer): integer
+  if a > 0
+    return a
+  endif
+endfunc
+run
+  print strInt(noReturn(0))
+endrun`)
+	runProgramPrintError(`
+func f(): string
+  return "x"
+endfunc
+run
+  call f()
+endrun`)
+	runProgramPrintError(`
+run
+  return
+endrun`)
+	// Output:
+	// Parsing error: test: Error at line 6: Function bump can not assign to global variable i
+	// Parsing error: test: Error at line 3: Statement sleep not allowed in function nap
+	// Interpreting error: Line 8: Function noReturn ended without returning a value
+	// Parsing error: test: Error at line 6: Call of function f (use it in an expression)
+	// Parsing error: test: Error at line 3: Return outside of a procedure or function
+}
+
+func ExampleInterp9() {
+	prog := `
+var
+  pages: counter
+  toner: integer
+endvar
+task printer
+  loop times 3
+    pages = pages + 1
+    sleep 20 msecs
+  endloop
+  print "printed " + strInt(pages)
+endtask
+task drain
+  loop
+    toner = toner - 1
+    sleep 10 msecs
+  endloop
+endtask
+run
+  toner = 100
+  wait printer
+  print "after printer " + strInt(pages)
+  print "toner used " + strBool(toner < 100)
+endrun`
+	runProgramPrintError(prog)
+	fmt.Println("drain stopped")
+	// Output:
+	// printed 3
+	// after printer 3
+	// toner used true
+	// drain stopped
+}
+
+func ExampleInterp10() {
+	runProgramPrintError(`
+func noReturn(): integer
+endfunc
+task broken
+  sleep 10 msecs
+  print strInt(noReturn())
+endtask
+run
+  sleep 10 secs
+  print "not reached"
+endrun`)
+	runProgramPrintError(`
+proc report()
+  wait second
+  print "second done"
+endproc
+task first
+  wait second
+  print "first after second"
+endtask
+task second
+  sleep 10 msecs
+endtask
+run
+  wait first
+  call report()
+endrun`)
+	runProgramPrintError(`
+task first
+  wait third
+endtask
+run
+endrun`)
+	runProgramPrintError(`
+task first
+  sleep 10 msecs
+  wait first
+endtask
+run
+endrun`)
+	// Output:
+	// Interpreting error: Task broken: Line 6: Function noReturn ended without returning a value
+	// first after second
+	// second done
+	// Parsing error: test: Error at line 3: Wait for unknown task: third
+	// Parsing error: test: Error at line 4: Task first can not wait for itself
+}
+
+func ExampleInterp11() {
+	prog := `
+var
+  ticks: integer
+endvar
+every 10 msecs
+  ticks = ticks + 1
+  if ticks = 3
+    print "every stopped at " + strInt(ticks)
+    break
+  endif
+endevery
+after 100 msecs
+  print "after sees " + strInt(ticks)
+endafter
+at 3:30
+  print "not reached"
+endat
+run
+  sleep 200 msecs
+  print "run done"
+endrun`
+	runProgramPrintError(prog)
+	runProgramPrintError(`
+at 25:00
+endat
+run
+endrun`)
+	// Output:
+	// every stopped at 3
+	// after sees 3
+	// run done
+	// Parsing error: test: Error at line 2: Invalid time of day (expecting hour 0-23, minute and second 0-59)
+}
+
+func ExampleNextTimeOfDay() {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	fmt.Println(nextTimeOfDay(now, &Task{atHour: 14}))
+	fmt.Println(nextTimeOfDay(now, &Task{atHour: 10}))
+	fmt.Println(nextTimeOfDay(now, &Task{atHour: 9, atMinute: 59, atSecond: 30}))
+	// Output:
+	// 2024-05-01 14:00:00 +0000 UTC
+	// 2024-05-02 10:00:00 +0000 UTC
+	// 2024-05-02 09:59:30 +0000 UTC
+}
+
+func ExampleInterp12() {
+	interp, done := startTestProgram(`
+var
+  mode: 4.1.1.5.0 rw integer
+  cmd: 4.1.1.6.0 rwb string
+  late: boolean
+endvar
+run
+  read mode timeout 1 secs timedout late
+  print "mode " + strInt(mode) + " late " + strBool(late)
+  read cmd
+  print "cmd " + cmd
+  loop times 2
+    select
+    case read mode
+      print "select mode " + strInt(mode)
+    case read cmd
+      print "select cmd " + cmd
+    timeout 200 msecs
+      print "select timed out"
+    endselect
+  endloop
+  read mode timeout 100 msecs timedout late
+  print "late " + strBool(late)
+endrun`)
+
+	source := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 1161}
+	time.Sleep(50 * time.Millisecond)
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.5.0", 3)
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.6.0", "go")
+	time.Sleep(50 * time.Millisecond)
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.6.0", "stop")
+
+	err := <-done
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// mode 3 late false
+	// cmd go
+	// select cmd stop
+	// select timed out
+	// late true
+}
+
+func ExampleInterp13() {
+	for _, prog := range []string{`
+var
+  mode: 4.1.1.5.0 integer
+endvar
+run
+  read mode
+endrun`, `
+var
+  mode: 4.1.1.5.0 rw integer
+endvar
+run
+  select
+  timeout 1 secs
+  endselect
+endrun`, `
+var
+  mode: 4.1.1.5.0 rw integer
+endvar
+run
+  select
+  case read mode
+  case read mode
+  endselect
+endrun`, `
+var
+  mode: 4.1.1.5.0 rw integer
+endvar
+run
+  select
+  timeout 1 secs
+  case read mode
+  endselect
+endrun`, `
+var
+  mode: 4.1.1.5.0 rw integer
+  select: integer
+  case: integer
+endvar
+run
+  select = 1
+  select
+  case read mode
+    if mode > 0
+      case = select + mode
+    endif
+  endselect
+endrun`} {
+		_, err := NewParser(lex("test", prog)).ParseProgram()
+		fmt.Println(err)
+	}
+	// Output:
+	// test: Error at line 6: Unable to read on non rw or rwb OID variable
+	// test: Error at line 8: Select statement without a case
+	// test: Error at line 8: Second case for mode in select statement
+	// test: Error at line 8: Case after timeout in select statement
+	// <nil>
+}
+
+func ExampleInterp14() {
+	interp, done := startTestProgram(`
+var
+  count: integer
+  late: boolean
+  mode: 4.1.1.5.0 rw integer
+endvar
+task ticker
+  loop times 5
+    sleep 10 msecs
+    count = count + 1
+  endloop
+endtask
+run
+  wait until count = 5
+  print "count reached " + strInt(count)
+  wait until mode = 2 & count = 5 timeout 1 secs timedout late
+  print "mode " + strInt(mode) + " late " + strBool(late)
+  wait until count > 5 timeout 50 msecs timedout late
+  print "late " + strBool(late)
+endrun`)
+
+	source := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 1161}
+	time.Sleep(150 * time.Millisecond)
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.5.0", 2)
+
+	err := <-done
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// count reached 5
+	// mode 2 late false
+	// late true
+}
+
+func ExampleInterp15() {
+	prog := `
+var
+  name: string
+  ver: oid
+  peer: ipaddress
+  flags: bitset [1 = 'paper', 2 = 'toner', 3 = 'jam']
+  ok: boolean
+  n: integer
+endvar
+run
+  name = "beta"
+  ver = .1.3.6.1.10
+  peer = 10.0.0.20
+  flags = ['paper', 'jam']
+  ok = true
+  n = 4
+  if name = "beta" & name # "alpha" & name > "alpha" & name != "gamma"
+    print "strings"
+  endif
+  if ver > .1.3.6.1.9 & ver < .1.3.6.2
+    print "oids ordered by component"
+  endif
+  if peer > 10.0.0.3 & peer = 10.0.0.20
+    print "addresses ordered numerically"
+  endif
+  if flags <= [1, 2, 3] & flags < [1, 2, 3] & flags = [3, 1] & !(flags >= ['toner'])
+    print "bitsets by subset"
+  endif
+  if ok = true & ok # false & flags contains 3
+    print "booleans"
+  endif
+  if n # 5 & n != 3
+    print "integers not equal"
+  endif
+endrun`
+	runProgramPrintError(prog)
+	for _, cond := range []string{`name = 3`, `n = name`, `peer = ver`, `ok < true`, `flags`} {
+		runProgramPrintError(`
+var
+  name: string
+  ver: oid
+  peer: ipaddress
+  flags: bitset
+  ok: boolean
+  n: integer
+endvar
+run
+  if ` + cond + `
+  endif
+endrun`)
+	}
+	// Output:
+	// strings
+	// oids ordered by component
+	// addresses ordered numerically
+	// bitsets by subset
+	// booleans
+	// integers not equal
+	// Parsing error: test: Error at line 11: Can not compare String with Integer
+	// Parsing error: test: Error at line 11: Can not compare Integer with String
+	// Parsing error: test: Error at line 11: Can not compare Ipaddress with Oid
+	// Parsing error: test: Error at line 11: Bad operator for boolean
+	// Parsing error: test: Error at line 12: Bitset in boolean expression missing "contains" or comparison
+}
+
+func ExampleInterp16() {
+	prog := `
+var
+  page: counter
+  status: integer
+  n: integer
+endvar
+run
+  page = 10
+  if page mod 3 = 1
+    print "every 3rd page"
+  endif
+  status = 0x80 bor 1 << 2 bor 0b11
+  print strInt(status) + " " + strInt(status band 0xf) + " " + strInt(status bxor 0xff)
+  print strInt(bnot 0) + " " + strInt(status >> 4) + " " + strInt(-0x10)
+  print strInt(17 mod 5 * 2) + " " + strInt(2 * 17 mod 5) + " " + strInt(1 + 6 / 2 * 3)
+  print strInt(min(page, 3)) + " " + strInt(max(page, 3)) + " " + strInt(abs(3 - page))
+  n = page / (page - 10)
+endrun`
+	runProgramPrintError(prog)
+	for _, bad := range []string{`n = 5 mod n`, `n = 1 << -1`, `n = 0x`, `n = 0b102`} {
+		runProgramPrintError(`
+var
+  n: integer
+endvar
+run
+  ` + bad + `
+endrun`)
+	}
+	// Output:
+	// every 3rd page
+	// 135 7 120
+	// -1 8 -16
+	// 4 4 10
+	// 3 10 7
+	// Interpreting error: Line 17: Division by zero
+	// Interpreting error: Line 6: Modulo by zero
+	// Interpreting error: Line 6: Negative shift count -1
+	// Parsing error: test: Error at line 6: bad number syntax: "0x"
+	// Parsing error: test: Error at line 6: bad number syntax: "0b102"
+}
+
+func ExampleInterp17() {
+	prog := `
+var
+  do-color: boolean
+  pages: integer
+  model: string
+  ver: oid
+  peer: ipaddress
+  errors: bitset [0 = 'low paper', 1 = 'no paper']
+  then: integer
+  ok: boolean
+endvar
+run
+  do-color = true
+  pages = 7
+  pages = if do-color then pages * 2 else 0
+  model = "HP " + if pages > 10 then "Color" else "Mono"
+  ver = if do-color & pages > 100 then .1.3.6.1.1 else .1.3.6.1.2
+  peer = if !do-color then 10.0.0.1 else 10.0.0.2
+  errors = (if pages mod 2 = 0 then ['no paper'] else []) + ['low paper']
+  print strInt(pages) + " " + model + " " + strOid(ver) + " " + strIpaddress(peer) + " " + strBitset(errors)
+  pages = 1 + (if pages > 100 then 1 else if pages > 10 then 2 else 3) * 10
+  print strInt(pages)
+  print format("%d %s", if do-color then 1 else 2, if pages > 100 then "big" else "small")
+  then = if do-color then 3 else 4
+  print strInt(then)
+  ok = if do-color then false else true
+  print strBool(ok) + " " + strBool(if pages > 100 then false else pages > 10 & do-color)
+  if pages > if do-color then 10 else 20
+    print "over the color limit"
+  endif
+endrun`
+	runProgramPrintError(prog)
+	for _, bad := range []string{`pages = if do-color then "one" else 0`, `model = if do-color then "one" else pages`, `pages = if do-color then 1`,
+		`model = format("%s", if do-color then "one" else 2)`, `pages = if do-color 1 else 2`} {
+		runProgramPrintError(`
+var
+  do-color: boolean
+  pages: integer
+  model: string
+endvar
+run
+  ` + bad + `
+endrun`)
+	}
+	// Output:
+	// 14 HP Color .1.3.6.1.2 10.0.0.2 {0, 1}
+	// 21
+	// 1 small
+	// 3
+	// false true
+	// over the color limit
+	// Parsing error: test: Error at line 8: Conditional expression of Integer has a branch of String
+	// Parsing error: test: Error at line 8: Conditional expression of String has a branch of Integer
+	// Parsing error: test: Error at line 9: Expecting else in conditional expression but got "new line"
+	// Parsing error: test: Error at line 8: Conditional expression of String has a branch of Integer
+	// Parsing error: test: Error at line 8: Expecting then in conditional expression but got "int literal"
+}
+
+func ExampleInterp18() {
+	prog := `
+var
+  error-state: bitset [0 = 'low paper', 1 = 'no paper', 2 = 'low toner', 3 = 'no toner', 4 = 'jam']
+  paper-errors: bitset [5 = 'door open']
+  device-status: string
+  bit: integer
+  count: integer
+endvar
+run
+  error-state = ['no paper', 'low toner', 'jam']
+  paper-errors = ['low paper', 'no paper']
+  if !isEmpty(error-state * paper-errors)
+    device-status = "warning"
+  endif
+  print device-status + " " + strBitset(error-state * paper-errors + [0] * [0, 2])
+  print strInt(count(error-state)) + " " + strInt(first(error-state)) + " " + strInt(first([]))
+  if error-state * paper-errors <= paper-errors & isEmpty([]) = true & !isEmpty(paper-errors)
+    print "subset"
+  endif
+  for bit in error-state - paper-errors
+    count = count + 1
+    print "error " + strInt(bit)
+  endfor
+  for bit in error-state
+    if bit > 1
+      break
+    endif
+    print "paper error " + strInt(bit)
+  endfor
+  print strInt(count) + " " + strInt(bit)
+endrun`
+	runProgramPrintError(prog)
+	for _, bad := range []string{"for device-status in error-state\n  endfor", "for bit error-state\n  endfor", "for bit in error-state\n  endloop"} {
+		runProgramPrintError(`
+var
+  error-state: bitset [0 = 'low paper']
+  device-status: string
+  bit: integer
+endvar
+run
+  ` + bad + `
+endrun`)
+	}
+	// Output:
+	// warning {0, 1}
+	// 3 1 -1
+	// subset
+	// error 2
+	// error 4
+	// paper error 1
+	// 2 2
+	// Parsing error: test: Error at line 8: Variable of for statement is not an integer: device-status
+	// Parsing error: test: Error at line 8: Expecting in after variable of for statement but got "error-state" (type identifier)
+	// Parsing error: test: Error at line 9: Expecting endfor in for but got "endloop"
+}
+
+func ExampleInterp19() {
+	// operator words are free for variables where no operator can go
+	runProgramPrintError(`
+var
+  mod: integer
+  band: integer
+  bnot: integer
+endvar
+run
+  mod = 17 mod 5
+  band = mod band 3
+  bnot = bnot 0 + mod
+  print strInt(mod) + " " + strInt(band) + " " + strInt(bnot) + " " + strInt(bnot - 1)
+  print strInt(mod mod (bnot band 7))
+endrun`)
+	// Output:
+	// 2 2 1 0
+	// 0
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -94,6 +95,8 @@ const (
 	itemMinus                              // '-'
 	itemTimes                              // '*'
 	itemDivide                             // '/'
+	itemShiftLeft                          // '<<'
+	itemShiftRight                         // '>>'
 	itemLeftParen                          // '('
 	itemRightParen                         // ')'
 	itemLeftSquareBracket                  // '['
@@ -149,6 +152,56 @@ const (
 	itemContains    // contains
 	itemBytes       // bytes (like a struct of fields of bytes - converts to string)
 	itemDot         // field name specifier
+	itemDump        // dump
+	itemProc        // proc
+	itemEndProc     // endproc
+	itemCall        // call
+	itemFunc        // func
+	itemEndFunc     // endfunc
+	itemReturn      // return
+	itemTask        // task
+	itemEndTask     // endtask
+	itemWait        // wait
+	itemEvery       // every
+	itemEndEvery    // endevery
+	itemAfter       // after
+	itemEndAfter    // endafter
+	itemAt          // at
+	itemEndAt       // endat
+	itemOn          // on
+	itemEndOn       // endon
+	itemPolls       // polls
+	itemEndSelect   // endselect
+	itemQueued      // queued
+	itemLen         // len
+	itemIndexOf     // indexOf
+	itemSubstr      // substr
+	itemUpper       // upper
+	itemLower       // lower
+	itemTrim        // trim
+	itemReplace     // replace
+	itemRepeat      // repeat
+	itemFormat      // format
+	itemIntStr      // intStr
+	itemOidStr      // oidStr
+	itemAddrStr     // addrStr
+	itemAddrInt     // addrInt
+	itemIntAddr     // intAddr
+	itemBitsetInt   // bitsetInt
+	itemIntBitset   // intBitset
+	itemMod         // mod
+	itemBitAnd      // band
+	itemBitOr       // bor
+	itemBitXor      // bxor
+	itemBitNot      // bnot
+	itemMin         // min
+	itemMax         // max
+	itemAbs         // abs
+	itemFor         // for
+	itemEndFor      // endfor
+	itemCount       // count
+	itemIsEmpty     // isEmpty
+	itemFirst       // first
 	itemNone
 )
 
@@ -208,12 +261,130 @@ var keywords = map[string]itemType{
 	"rwb":          itemRWB,
 	"read":         itemRead,
 	"contains":     itemContains,
+	"dump":         itemDump,
+	"proc":         itemProc,
+	"endproc":      itemEndProc,
+	"call":         itemCall,
+	"func":         itemFunc,
+	"endfunc":      itemEndFunc,
+	"return":       itemReturn,
+	"task":         itemTask,
+	"endtask":      itemEndTask,
+	"wait":         itemWait,
+	"every":        itemEvery,
+	"endevery":     itemEndEvery,
+	"after":        itemAfter,
+	"endafter":     itemEndAfter,
+	"at":           itemAt,
+	"endat":        itemEndAt,
+	"on":           itemOn,
+	"endon":        itemEndOn,
+	"polls":        itemPolls,
+	"endselect":    itemEndSelect,
+	"queued":       itemQueued,
+	"len":          itemLen,
+	"indexOf":      itemIndexOf,
+	"substr":       itemSubstr,
+	"upper":        itemUpper,
+	"lower":        itemLower,
+	"trim":         itemTrim,
+	"replace":      itemReplace,
+	"repeat":       itemRepeat,
+	"format":       itemFormat,
+	"intStr":       itemIntStr,
+	"oidStr":       itemOidStr,
+	"addrStr":      itemAddrStr,
+	"addrInt":      itemAddrInt,
+	"intAddr":      itemIntAddr,
+	"bitsetInt":    itemBitsetInt,
+	"intBitset":    itemIntBitset,
+	"mod":          itemMod,
+	"band":         itemBitAnd,
+	"bor":          itemBitOr,
+	"bxor":         itemBitXor,
+	"bnot":         itemBitNot,
+	"min":          itemMin,
+	"max":          itemMax,
+	"abs":          itemAbs,
+	"for":          itemFor,
+	"endfor":       itemEndFor,
+	"count":        itemCount,
+	"isEmpty":      itemIsEmpty,
+	"first":        itemFirst,
+}
+
+// isCallKeyword reports whether the keyword is only a keyword when called, e.g. len(s),
+// so programs can still name variables and tasks after the builtins
+func isCallKeyword(item itemType) bool {
+	_, ok := builtins[item]
+	return ok || variableCallKeywords[item]
+}
+
+// keywords called like builtins but on a variable rather than a value, e.g. polls(x)
+var variableCallKeywords = map[itemType]bool{
+	itemPolls:  true,
+	itemQueued: true,
+}
+
+// binary operators spelt as words which are only keywords following an operand,
+// so programs can still name variables after them, e.g. mod = n mod 2
+var infixKeywords = map[itemType]bool{
+	itemMod:    true,
+	itemBitAnd: true,
+	itemBitOr:  true,
+	itemBitXor: true,
+}
+
+// isOperandEnd reports whether an item can end an integer operand
+func isOperandEnd(t itemType) bool {
+	switch t {
+	case itemIdentifier, itemIntegerLiteral, itemAlias, itemRightParen:
+		return true
+	default:
+		return false
+	}
+}
+
+// isOperandStart reports whether the input, after any spaces, can start an integer operand
+// A following binary operator word such as band means the input is not an operand.
+func isOperandStart(input string) bool {
+	input = strings.TrimLeft(input, " \t")
+	if input == "" {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(input)
+	if isAlpha(r) {
+		word := input
+		if end := strings.IndexFunc(input, isEndOfWord); end >= 0 {
+			word = input[:end]
+		}
+		return !infixKeywords[keywords[word]]
+	}
+	return isAlphaNumeric(r) || r == '(' || r == '\''
+}
+
+// isKeywordHere reports whether the keyword just scanned is used as a keyword
+// rather than naming a variable, given the rune ending it
+func (l *lexer) isKeywordHere(item itemType, end rune) bool {
+	switch {
+	case isCallKeyword(item):
+		return end == '('
+	case infixKeywords[item]:
+		return isOperandEnd(l.prevItemType)
+	case item == itemBitNot:
+		// a unary operator is followed by its operand
+		return !isOperandEnd(l.prevItemType) && isOperandStart(l.input[l.pos:])
+	default:
+		return true
+	}
 }
 
 var symbols = map[string]itemType{
 	"<":  itemLessThan,
 	">":  itemGreaterThan,
 	"<=": itemLessEquals,
+	"<<": itemShiftLeft,
+	">>": itemShiftRight,
 	">=": itemGreaterEquals,
 	"=":  itemEquals,
 	"==": itemEquals, // added to mimic C, java, go, etc...
@@ -356,6 +527,13 @@ func (l *lexer) emit(t itemType) {
 	l.start = l.pos
 }
 
+// emitValue passes an item back to the client with a value other than its text
+func (l *lexer) emitValue(t itemType, val string) {
+	l.items <- item{t, l.start, val, l.line}
+	l.prevItemType = t
+	l.start = l.pos
+}
+
 // errorf returns an error token
 func (l *lexer) errorf(format string, args ...interface{}) {
 	l.items <- item{itemError, l.start, fmt.Sprintf(format, args...), l.line}
@@ -505,8 +683,8 @@ func processSymbol(l *lexer) processResult {
 		return resultMatch
 	}
 	// no 1 or 2 char symbol matches
-	l.backup()
-	l.backup()
+	// reset rather than back up twice as there is no width to back up at the end of input
+	l.reset()
 	return resultNoMatch
 }
 
@@ -518,18 +696,87 @@ func processStringLiteral(l *lexer) processResult {
 	l.next()
 	l.ignore()
 
-	// now look for matching "
-	if l.acceptNotRun("\"") {
-		l.emit(itemStringLiteral)
-		l.next()
-		l.ignore()
-		return resultMatch
-	} else {
-		l.errorf("Could not find string terminator")
-		return resultMatchError
+	// now look for matching " skipping escaped characters
+	for {
+		switch l.next() {
+		case '"':
+			l.backup()
+			str, err := unescape(l.input[l.start:l.pos])
+			if err != nil {
+				l.errorf("%v", err)
+				return resultMatchError
+			}
+			l.emitValue(itemStringLiteral, str)
+			l.next()
+			l.ignore()
+			return resultMatch
+		case '\\':
+			l.next()
+		case eof:
+			l.errorf("Could not find string terminator")
+			return resultMatchError
+		}
 	}
 }
 
+var escapes = map[byte]byte{
+	'n': '\n', 't': '\t', 'r': '\r', 'a': '\a', 'b': '\b', 'f': '\f', 'v': '\v',
+	'\\': '\\', '"': '"', '\'': '\'', '?': '?',
+}
+
+// unescape replaces the C style escapes of a string literal
+// including \xhh hex and \ooo octal bytes
+func unescape(str string) (string, error) {
+	if !strings.ContainsRune(str, '\\') {
+		return str, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\\' {
+			b.WriteByte(str[i])
+			continue
+		}
+		i++
+		if i == len(str) {
+			return "", fmt.Errorf("Incomplete escape at end of string")
+		}
+		if c, ok := escapes[str[i]]; ok {
+			b.WriteByte(c)
+			continue
+		}
+
+		// a number of up to 2 hex or 3 octal digits
+		base, maxDigits, start := 8, 3, i
+		if str[i] == 'x' {
+			base, maxDigits, start = 16, 2, i+1
+		}
+		end := start
+		for end < len(str) && end-start < maxDigits && isDigitOfBase(str[end], base) {
+			end++
+		}
+		if end == start {
+			return "", fmt.Errorf("Invalid escape \\%c in string", str[i])
+		}
+		x, err := strconv.ParseUint(str[start:end], base, 16)
+		if err != nil || x > 0xff {
+			return "", fmt.Errorf("Invalid escape \\%s in string", str[i:end])
+		}
+		b.WriteByte(byte(x))
+		i = end - 1
+	}
+	return b.String(), nil
+}
+
+func isDigitOfBase(c byte, base int) bool {
+	switch {
+	case c >= '0' && c <= '7':
+		return true
+	case base == 16 && (c >= '8' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'):
+		return true
+	}
+	return false
+}
+
 func processAlias(l *lexer) processResult {
 	if l.peek() != '\'' {
 		return resultNoMatch
@@ -594,29 +841,54 @@ func processOidLiteral(l *lexer) processResult {
 }
 
 func processNumericLiteral(l *lexer) processResult {
-	r := l.next()
+	r := l.peek()
 	if r != '+' && r != '-' && !('0' <= r && r <= '9') {
-		l.backup()
 		return resultNoMatch
 	}
 
 	// Optional leading sign.
 	l.accept("+-")
 
-	// Is it hex?
-	digits := "0123456789"
-	if l.accept("0") && l.accept("xX") {
-		digits = "0123456789abcdefABCDEF"
+	// Is it hex or binary?
+	digits, base := "0123456789", 10
+	zeroPos := l.pos
+	if l.accept("0") {
+		if l.accept("xX") {
+			digits, base = "0123456789abcdefABCDEF", 16
+		} else if l.accept("bB") {
+			digits, base = "01", 2
+		} else {
+			l.pos = zeroPos // the 0 is a decimal digit
+		}
 	}
+	prefixEnd := l.pos
 	l.acceptRun(digits)
 
 	// Next thing mustn't be alphanumeric.
-	if isAlphaNumeric(l.peek()) {
-		l.next()
+	if isAlphaNumeric(l.peek()) || l.pos == prefixEnd {
+		if isAlphaNumeric(l.peek()) {
+			l.next()
+		}
+		l.errorf("bad number syntax: %q", l.input[l.start:l.pos])
+		return resultMatchError
+	}
+	if base == 10 {
+		l.emit(itemIntegerLiteral)
+		return resultMatch
+	}
+
+	// pass on hex and binary numbers in decimal
+	str := l.input[l.start:l.pos]
+	sign := ""
+	if str[0] == '+' || str[0] == '-' {
+		sign, str = str[:1], str[1:]
+	}
+	x, err := strconv.ParseUint(str[2:], base, 63)
+	if err != nil {
 		l.errorf("bad number syntax: %q", l.input[l.start:l.pos])
 		return resultMatchError
 	}
-	l.emit(itemIntegerLiteral)
+	l.emitValue(itemIntegerLiteral, sign+strconv.FormatUint(x, 10))
 	return resultMatch
 }
 
@@ -636,7 +908,7 @@ func processKeyword(l *lexer) processResult {
 			// now look up word
 			word := l.itemString()
 			// fmt.Printf("-> Look up %s\n", word)
-			if item, ok := keywords[word]; ok {
+			if item, ok := keywords[word]; ok && l.isKeywordHere(item, rune) {
 				l.emit(item)
 				return resultMatch
 			} else {
@@ -689,7 +961,7 @@ func isAlpha(r rune) bool {
 }
 
 func isEndOfWord(r rune) bool {
-	return isSpace(r) || isEndOfLine(r) || r == eof || r == '('
+	return isSpace(r) || isEndOfLine(r) || r == eof || r == '(' || r == ')' || r == ','
 }
 
 // Is item allow arguments to span on next line or not?
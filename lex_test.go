@@ -279,3 +279,18 @@ func ExampleLexing4() {
 	// item: <endvar>
 	// item: EOF
 }
+
+func ExampleLexing5() {
+	// numbers at the end of the input
+	printTokens(lex("test", "x = 0"))
+	printTokens(lex("test", "x = 12"))
+	// Output:
+	// item: "x" (type identifier)
+	// item: "=" (type =)
+	// item: "0" (type int literal)
+	// item: EOF
+	// item: "x" (type identifier)
+	// item: "=" (type =)
+	// item: "12" (type int literal)
+	// item: EOF
+}
@@ -47,6 +47,12 @@ const (
 	StmtSleep
 	StmtBreak
 	StmtRead
+	StmtDump
+	StmtCall
+	StmtReturn
+	StmtWait
+	StmtSelect
+	StmtFor
 )
 
 const (
@@ -63,6 +69,7 @@ const (
 	ExprnBitset
 	ExprnOid
 	ExprnAddr
+	ExprnConditional
 )
 
 const (
@@ -87,6 +94,7 @@ const (
 	IntCompLessEquals
 	IntCompGreaterEquals
 	IntCompEquals
+	IntCompNotEquals
 )
 
 const (
@@ -102,6 +110,9 @@ const (
 
 type Program struct {
 	variables *Variables
+	procs     map[string]*Procedure
+	tasks     []*Task // in order of definition
+	handlers  []*Handler
 	stmtList  []*Statement
 }
 
@@ -114,6 +125,12 @@ type Variables struct {
 type Parser struct {
 	prefixOid string // OID prefix used if oid not prefixed by dot
 	variables *Variables
+	procs     map[string]*Procedure
+	locals    map[string]*Type // parameters of the procedure being parsed
+	proc      *Procedure       // procedure or function being parsed
+	tasks     map[string]*Task
+	task      *Task      // task being parsed
+	taskWaits []TaskWait // checked once all the tasks are known
 
 	lex   *lexer
 	token item
@@ -157,6 +174,13 @@ func (parser *Parser) match(itemTyp itemType, context string) (err error) {
 	return err
 }
 
+// peekWord reports whether the next token is the word
+// Words such as timeout are not keywords so they can still name variables.
+func (parser *Parser) peekWord(word string) bool {
+	item := parser.peek()
+	return item.typ == itemIdentifier && item.val == word
+}
+
 //-------------------------------------------------------------------------------
 
 func printIndent(indent int) {
@@ -174,9 +198,61 @@ func printfIndent(indent int, format string, a ...interface{}) {
 func PrintProgram(prog *Program, indent int) {
 	printfIndent(indent, "Program\n")
 	PrintVariables(prog.variables, indent+1)
+	PrintProcedures(prog.procs, indent+1)
+	PrintTasks(prog.tasks, indent+1)
+	PrintHandlers(prog.handlers, indent+1)
 	PrintStatementList(prog.stmtList, indent+1)
 }
 
+func PrintProcedures(procs map[string]*Procedure, indent int) {
+	if len(procs) == 0 {
+		return
+	}
+	// sort for testing predictability
+	names := make([]string, 0)
+	for name := range procs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		proc := procs[name]
+		if proc.returnType != ValueNone {
+			printfIndent(indent, "Function %s: %v\n", name, Type{valueType: proc.returnType})
+		} else {
+			printfIndent(indent, "Procedure %s\n", name)
+		}
+		for i, param := range proc.params {
+			printfIndent(indent+1, "[%d] param %s: %v\n", i, param.id, param)
+		}
+		PrintStatementList(proc.stmtList, indent+1)
+	}
+}
+
+func PrintTasks(tasks []*Task, indent int) {
+	for _, task := range tasks {
+		switch task.taskType {
+		case TaskNamed:
+			printfIndent(indent, "Task %s\n", task.name)
+		case TaskEvery:
+			printfIndent(indent, "Every\n")
+			PrintDuration(task.interval, task.units, indent+1)
+		case TaskAfter:
+			printfIndent(indent, "After\n")
+			PrintDuration(task.interval, task.units, indent+1)
+		case TaskAt:
+			printfIndent(indent, "At %02d:%02d:%02d\n", task.atHour, task.atMinute, task.atSecond)
+		}
+		PrintStatementList(task.stmtList, indent+1)
+	}
+}
+
+func PrintHandlers(handlers []*Handler, indent int) {
+	for _, handler := range handlers {
+		printfIndent(indent, "On %v %s\n", handler.handlerType, handler.identifier)
+		PrintStatementList(handler.stmtList, indent+1)
+	}
+}
+
 func PrintVariables(vars *Variables, indent int) {
 	printfIndent(indent, "Variables\n")
 
@@ -229,6 +305,18 @@ func PrintOneStatement(stmt *Statement, indent int) {
 		PrintPrintStmt(stmt.printStmt, indent+1)
 	case StmtRead:
 		PrintReadStmt(stmt.readStmt, indent+1)
+	case StmtDump:
+		PrintDumpStmt(stmt.dumpStmt, indent+1)
+	case StmtCall:
+		PrintCallStmt(stmt.callStmt, indent+1)
+	case StmtReturn:
+		PrintReturnStmt(stmt.returnStmt, indent+1)
+	case StmtWait:
+		PrintWaitStmt(stmt.waitStmt, indent+1)
+	case StmtSelect:
+		PrintSelectStmt(stmt.selectStmt, indent+1)
+	case StmtFor:
+		PrintForStmt(stmt.forStmt, indent+1)
 	case StmtBreak:
 		printfIndent(indent, "Break\n")
 	}
@@ -273,18 +361,89 @@ func PrintPrintStmt(printStmt *PrintStatement, indent int) {
 
 func PrintSleepStmt(sleepStmt *SleepStatement, indent int) {
 	printfIndent(indent, "Sleep Statement\n")
-	PrintIntExpression(sleepStmt.exprn, indent+1)
-	switch sleepStmt.units {
+	PrintDuration(sleepStmt.exprn, sleepStmt.units, indent+1)
+}
+
+func PrintDuration(exprn *IntExpression, units TimeUnit, indent int) {
+	PrintIntExpression(exprn, indent)
+	switch units {
 	case TimeSecs:
-		printfIndent(indent+1, "secs\n")
+		printfIndent(indent, "secs\n")
 	case TimeMillis:
-		printfIndent(indent+1, "msecs\n")
+		printfIndent(indent, "msecs\n")
 	}
 }
 
 func PrintReadStmt(readStmt *ReadStatement, indent int) {
 	printfIndent(indent, "Read Statement\n")
 	printfIndent(indent, "id: %s", readStmt.identifier)
+	PrintTimeout(readStmt.timeout, indent+1)
+}
+
+func PrintSelectStmt(selectStmt *SelectStatement, indent int) {
+	printfIndent(indent, "Select Statement\n")
+	for i, selectCase := range selectStmt.cases {
+		printfIndent(indent+1, "[%d] case read %s\n", i, selectCase.identifier)
+		PrintStatementList(selectCase.stmtList, indent+2)
+	}
+	if selectStmt.timeout != nil {
+		PrintTimeout(selectStmt.timeout, indent+1)
+		PrintStatementList(selectStmt.timeoutStmtList, indent+2)
+	}
+}
+
+func PrintDumpStmt(dumpStmt *DumpStatement, indent int) {
+	printfIndent(indent, "Dump Statement\n")
+	PrintStringExpression(dumpStmt.filename, indent+1)
+}
+
+func PrintCallStmt(callStmt *CallStatement, indent int) {
+	printfIndent(indent, "Call Statement\n")
+	PrintCall(callStmt.call, indent+1)
+}
+
+func PrintReturnStmt(returnStmt *ReturnStatement, indent int) {
+	printfIndent(indent, "Return Statement\n")
+	if returnStmt.exprn != nil {
+		PrintExpression(returnStmt.exprn, indent+1)
+	}
+}
+
+func PrintWaitStmt(waitStmt *WaitStatement, indent int) {
+	printfIndent(indent, "Wait Statement\n")
+	switch waitStmt.waitType {
+	case WaitTask:
+		printfIndent(indent+1, "Task: %s\n", waitStmt.taskName)
+	case WaitPoll:
+		printfIndent(indent+1, "Poll: %s\n", waitStmt.identifier)
+		if waitStmt.times != nil {
+			printfIndent(indent+1, "Times\n")
+			PrintIntExpression(waitStmt.times, indent+2)
+		}
+	case WaitUntil:
+		printfIndent(indent+1, "Until\n")
+		PrintBooleanExpression(waitStmt.condition, indent+2)
+	}
+	PrintTimeout(waitStmt.timeout, indent+1)
+}
+
+func PrintTimeout(timeout *Timeout, indent int) {
+	if timeout == nil {
+		return
+	}
+	printfIndent(indent, "Timeout\n")
+	PrintDuration(timeout.exprn, timeout.units, indent+1)
+	if timeout.timedOutId != "" {
+		printfIndent(indent+1, "Timed out: %s\n", timeout.timedOutId)
+	}
+}
+
+func PrintCall(call *Call, indent int) {
+	printfIndent(indent, "Call %s\n", call.name)
+	for i, arg := range call.args {
+		printfIndent(indent+1, "[%d] arg\n", i)
+		PrintExpression(arg, indent+1)
+	}
 }
 
 func PrintLoopStmt(loopStmt *LoopStatement, indent int) {
@@ -298,6 +457,12 @@ func PrintLoopStmt(loopStmt *LoopStatement, indent int) {
 	PrintStatementList(loopStmt.stmtList, indent+1)
 }
 
+func PrintForStmt(forStmt *ForStatement, indent int) {
+	printfIndent(indent, "For Statement %s in\n", forStmt.identifier)
+	PrintBitsetExpression(forStmt.bitsetExpression, indent+1)
+	PrintStatementList(forStmt.stmtList, indent+1)
+}
+
 func printElseIfStmt(elseif *ElseIf, indent int) {
 	printfIndent(indent, "elsif expression\n")
 	PrintBooleanExpression(elseif.boolExpression, indent+1)
@@ -319,6 +484,8 @@ func PrintExpression(exprn *Expression, indent int) {
 		PrintBitsetExpression(exprn.bitsetExpression, indent+1)
 	case ExprnBytes:
 		PrintBytesExpression(exprn.bytesExpression, indent+1)
+	case ExprnConditional:
+		PrintConditional(exprn.conditional, indent+1)
 	}
 }
 
@@ -351,6 +518,18 @@ func PrintBitsetTerm(index int, bitsetTerm *BitsetTerm, indent int) {
 	case BitsetTermBracket:
 		printfIndent(indent, "Bracketed Bitset Expression\n")
 		PrintBitsetExpression(bitsetTerm.bracketedExprn, indent+1)
+	case BitsetTermCall:
+		printfIndent(indent, "Function call\n")
+		PrintCall(bitsetTerm.call, indent+1)
+	case BitsetTermBuiltin:
+		PrintBuiltinCall(bitsetTerm.builtin, indent)
+	case BitsetTermConditional:
+		PrintConditional(bitsetTerm.conditional, indent)
+	case BitsetTermIntersection:
+		printfIndent(indent, "Intersection\n")
+		for i, term := range bitsetTerm.intersectTerms {
+			PrintBitsetTerm(i, term, indent+1)
+		}
 	}
 }
 
@@ -395,6 +574,13 @@ func PrintOidAddTerm(i int, term *OidTerm, indent int) {
 	case OidTermBracket:
 		printfIndent(indent, "Bracketed Oid Expression\n")
 		PrintOidExpression(term.bracketedExprn, indent+1)
+	case OidTermCall:
+		printfIndent(indent, "Function call\n")
+		PrintCall(term.call, indent+1)
+	case OidTermBuiltin:
+		PrintBuiltinCall(term.builtin, indent)
+	case OidTermConditional:
+		PrintConditional(term.conditional, indent)
 	}
 }
 
@@ -414,6 +600,15 @@ func PrintStrAddTerm(i int, term *StringTerm, indent int) {
 	case StringTermStringedIntExprn:
 		printfIndent(indent, "Stringify Int Expression\n")
 		PrintIntExpression(term.stringedIntExprn, indent+1)
+	case StringTermCall:
+		printfIndent(indent, "Function call\n")
+		PrintCall(term.call, indent+1)
+	case StringTermBuiltin:
+		PrintBuiltinCall(term.builtin, indent)
+	case StringTermStringedId:
+		printfIndent(indent, "Stringify Identifier: %s\n", term.identifier)
+	case StringTermConditional:
+		PrintConditional(term.conditional, indent)
 	}
 }
 
@@ -463,11 +658,34 @@ func PrintBoolFactor(i int, factor *BoolFactor, indent int) {
 		printfIndent(indent, "%v\n", factor.intComparison.intComparator)
 		PrintIntExpression(factor.intComparison.lhsIntExpression, indent+1)
 		PrintIntExpression(factor.intComparison.rhsIntExpression, indent+1)
+	case BoolFactorCall:
+		printfIndent(indent, "Function call\n")
+		PrintCall(factor.call, indent+1)
+	case BoolFactorBuiltin:
+		PrintBuiltinCall(factor.builtin, indent)
+	case BoolFactorConditional:
+		PrintConditional(factor.conditional, indent)
+	case BoolFactorStrContains:
+		printfIndent(indent, "Contains factor for string\n")
+		PrintStringExpression(factor.strContainer, indent+1)
+		printfIndent(indent, "contains string:\n")
+		PrintStringExpression(factor.strElement, indent+1)
+	case BoolFactorComparison:
+		printfIndent(indent, "%v comparison\n", Type{valueType: factor.comparison.valueType})
+		printfIndent(indent, "%v\n", factor.comparison.comparator)
+		PrintExpression(factor.comparison.lhs, indent+1)
+		PrintExpression(factor.comparison.rhs, indent+1)
 	}
 }
 
 func PrintIntExpression(exprn *IntExpression, indent int) {
 	printfIndent(indent, "Integer Expression\n")
+	if exprn.intOp != IntOpNone {
+		printfIndent(indent, "%v\n", exprn.intOp)
+		PrintIntExpression(exprn.lhs, indent+1)
+		PrintIntExpression(exprn.rhs, indent+1)
+		return
+	}
 	if len(exprn.plusTerms) > 0 {
 		PrintPlusTerms(exprn.plusTerms, indent)
 	}
@@ -498,12 +716,18 @@ func PrintPlusTerm(i int, term *IntTerm, indent int) {
 	if len(term.divideFactors) > 0 {
 		PrintDivideFactors(term.divideFactors, indent+1)
 	}
+	if len(term.modFactors) > 0 {
+		PrintModFactors(term.modFactors, indent+1)
+	}
 }
 
 func PrintMinusTerm(i int, term *IntTerm, indent int) {
 	printfIndent(indent, "[%d]: minus term\n", i)
 	PrintTimesFactors(term.timesFactors, indent+1)
 	PrintDivideFactors(term.divideFactors, indent+1)
+	if len(term.modFactors) > 0 {
+		PrintModFactors(term.modFactors, indent+1)
+	}
 }
 
 func PrintTimesFactors(timesFactors []*IntFactor, indent int) {
@@ -520,12 +744,24 @@ func PrintDivideFactors(divideFactors []*IntFactor, indent int) {
 	}
 }
 
+func PrintModFactors(modFactors []*IntFactor, indent int) {
+	printfIndent(indent, "Mod Factors\n")
+	for i, factor := range modFactors {
+		PrintIntFactor(i, factor, indent+1)
+	}
+}
+
 func PrintIntFactor(i int, factor *IntFactor, indent int) {
 	printfIndent(indent, "[%d]: factor\n", i)
 	switch factor.intFactorType {
 	case IntFactorMinus:
 		printfIndent(indent, "Minus factor\n")
 		PrintIntFactor(i, factor.minusIntFactor, indent+1)
+	case IntFactorBitNot:
+		printfIndent(indent, "Bitwise not factor\n")
+		PrintIntFactor(i, factor.bitNotFactor, indent+1)
+	case IntFactorConditional:
+		PrintConditional(factor.conditional, indent)
 	case IntFactorConst:
 		printfIndent(indent, "Const factor: %d\n", factor.intConst)
 	case IntFactorId:
@@ -533,6 +769,15 @@ func PrintIntFactor(i int, factor *IntFactor, indent int) {
 	case IntFactorBracket:
 		printfIndent(indent, "Bracket expression\n")
 		PrintIntExpression(factor.bracketedExprn, indent+1)
+	case IntFactorCall:
+		printfIndent(indent, "Function call\n")
+		PrintCall(factor.call, indent+1)
+	case IntFactorPolls:
+		printfIndent(indent, "Polls factor: %s\n", factor.intIdentifier)
+	case IntFactorQueued:
+		printfIndent(indent, "Queued factor: %s\n", factor.intIdentifier)
+	case IntFactorBuiltin:
+		PrintBuiltinCall(factor.builtin, indent)
 	}
 }
 
@@ -551,6 +796,16 @@ func (parser *Parser) ParseProgram() (prog *Program, err error) {
 	}
 	parser.variables = prog.variables
 
+	prog.procs, err = parser.parseProcedures()
+	if err != nil {
+		return nil, err
+	}
+
+	err = parser.parseTasks(prog)
+	if err != nil {
+		return nil, err
+	}
+
 	err = parser.match(itemRun, "program")
 	if err != nil {
 		return nil, err
@@ -570,6 +825,11 @@ func (parser *Parser) ParseProgram() (prog *Program, err error) {
 	if err != nil {
 		return nil, err
 	}
+
+	err = parser.checkTaskWaits()
+	if err != nil {
+		return nil, err
+	}
 	return prog, nil
 }
 
@@ -735,6 +995,31 @@ func (parser *Parser) parseAliases(vars *Variables) (err error) {
 	}
 }
 
+// optional queue of a rwb variable
+// rwb [<size>] [block | drop-oldest | reject]
+func (parser *Parser) parseSetQueue(typ *Type) error {
+	typ.queueSize = defaultQueueSize
+	typ.queuePolicy = QueueBlock
+
+	if parser.peek().typ == itemIntegerLiteral {
+		item := parser.nextItem()
+		size, _ := strconv.Atoi(item.val)
+		if size <= 0 {
+			return parser.errorf("Queue size of rwb variable is not positive")
+		}
+		typ.queueSize = size
+	}
+	if parser.peek().typ == itemIdentifier {
+		item := parser.nextItem()
+		policy, ok := queuePolicies[item.val]
+		if !ok {
+			return parser.errorf("Unknown queue policy of rwb variable: %s", item.val)
+		}
+		typ.queuePolicy = policy
+	}
+	return nil
+}
+
 func (parser *Parser) parseType(vars *Variables, initMode InitMode, id string) (typ *Type, err error) {
 	typ = new(Type)
 	typ.initMode = initMode
@@ -760,6 +1045,10 @@ func (parser *Parser) parseType(vars *Variables, initMode InitMode, id string) (
 			item = parser.nextItem()
 		case itemRWB:
 			typ.snmpMode = SnmpModeReadWriteBlocked
+			err = parser.parseSetQueue(typ)
+			if err != nil {
+				return nil, err
+			}
 			item = parser.nextItem()
 		}
 	}
@@ -826,16 +1115,46 @@ func (parser *Parser) parseType(vars *Variables, initMode InitMode, id string) (
 }
 
 func (parser *Parser) lookupType(id string) ValueType {
-	typ, ok := parser.variables.types[id]
+	typ, ok := parser.locals[id]
+	if ok {
+		return typ.valueType
+	}
+	typ, ok = parser.variables.types[id]
 	if ok {
 		return typ.valueType
 	}
 	return ValueNone
 }
 
+// lookupFunc gets the function of the name returning one of the types
+// or nil if there is none or a variable hides it
+func (parser *Parser) lookupFunc(id string, valueTypes ...ValueType) *Procedure {
+	if parser.lookupType(id) != ValueNone {
+		return nil
+	}
+	proc, ok := parser.procs[id]
+	if !ok {
+		return nil
+	}
+	for _, valueType := range valueTypes {
+		if proc.returnType == valueType {
+			return proc
+		}
+	}
+	return nil
+}
+
+// inFunc reports whether a function body is being parsed
+func (parser *Parser) inFunc() bool {
+	return parser.proc != nil && parser.proc.returnType != ValueNone
+}
+
 func isStmtListEndKeyword(i item) bool {
 	return i.typ == itemEndRun || i.typ == itemEndLoop || i.typ == itemEndIf ||
-		i.typ == itemElse || i.typ == itemElseIf
+		i.typ == itemElse || i.typ == itemElseIf || i.typ == itemEndProc || i.typ == itemEndFunc ||
+		i.typ == itemEndTask || i.typ == itemEndEvery || i.typ == itemEndAfter || i.typ == itemEndAt ||
+		i.typ == itemEndOn || i.typ == itemEndSelect ||
+		i.typ == itemEndFor
 
 }
 
@@ -854,7 +1173,12 @@ func (parser *Parser) parseStatementList() ([]*Statement, error) {
 }
 
 func (parser *Parser) errorf(format string, a ...interface{}) error {
-	preamble := fmt.Sprintf("%s: Error at line %d: ", parser.lex.name, parser.token.line)
+	return parser.errorAtf(parser.token.line, format, a...)
+}
+
+// errorAtf reports an error at an earlier line
+func (parser *Parser) errorAtf(line int, format string, a ...interface{}) error {
+	preamble := fmt.Sprintf("%s: Error at line %d: ", parser.lex.name, line)
 	return fmt.Errorf(preamble+format, a...)
 }
 
@@ -862,10 +1186,30 @@ func (parser *Parser) parseStatement() (stmt *Statement, err error) {
 	stmt = new(Statement)
 
 	item := parser.peek()
+	if parser.inFunc() {
+		// functions only work out their value
+		switch item.typ {
+		case itemSleep, itemRead, itemDump, itemCall, itemWait:
+			return nil, parser.errorf("Statement %s not allowed in function %s", item.val, parser.proc.name)
+		}
+	}
 	switch item.typ {
 	case itemIdentifier:
+		idItem := parser.nextItem()
+		if idItem.val == "select" && parser.peek().typ == itemNewLine {
+			// select is not a keyword so it can still name a variable
+			if parser.inFunc() {
+				return nil, parser.errorf("Statement select not allowed in function %s", parser.proc.name)
+			}
+			stmt.stmtType = StmtSelect
+			stmt.selectStmt, err = parser.parseSelectStatement()
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		stmt.stmtType = StmtAssignment
-		stmt.assignmentStmt, err = parser.parseAssignment()
+		stmt.assignmentStmt, err = parser.parseAssignment(idItem)
 		if err != nil {
 			return nil, err
 		}
@@ -883,6 +1227,13 @@ func (parser *Parser) parseStatement() (stmt *Statement, err error) {
 		if err != nil {
 			return nil, err
 		}
+	case itemFor:
+		parser.nextItem()
+		stmt.stmtType = StmtFor
+		stmt.forStmt, err = parser.parseForStatement()
+		if err != nil {
+			return nil, err
+		}
 	case itemPrint:
 		parser.nextItem()
 		stmt.stmtType = StmtPrint
@@ -909,6 +1260,34 @@ func (parser *Parser) parseStatement() (stmt *Statement, err error) {
 		if err != nil {
 			return nil, err
 		}
+	case itemDump:
+		parser.nextItem()
+		stmt.stmtType = StmtDump
+		stmt.dumpStmt, err = parser.parseDumpStatement()
+		if err != nil {
+			return nil, err
+		}
+	case itemCall:
+		parser.nextItem()
+		stmt.stmtType = StmtCall
+		stmt.callStmt, err = parser.parseCallStatement()
+		if err != nil {
+			return nil, err
+		}
+	case itemReturn:
+		parser.nextItem()
+		stmt.stmtType = StmtReturn
+		stmt.returnStmt, err = parser.parseReturnStatement()
+		if err != nil {
+			return nil, err
+		}
+	case itemWait:
+		parser.nextItem()
+		stmt.stmtType = StmtWait
+		stmt.waitStmt, err = parser.parseWaitStatement()
+		if err != nil {
+			return nil, err
+		}
 
 	default:
 		return nil, parser.errorf("Missing leading statement token. Got %v", item)
@@ -917,72 +1296,771 @@ func (parser *Parser) parseStatement() (stmt *Statement, err error) {
 	return stmt, err
 }
 
-func (parser *Parser) parsePrintStatement() (printStmt *PrintStatement, err error) {
-	printStmt = new(PrintStatement)
+// types which can be given to parameters
+var paramTypes = map[itemType]ValueType{
+	itemString:      ValueString,
+	itemInteger:     ValueInteger,
+	itemCounter:     ValueCounter,
+	itemGauge:       ValueGuage,
+	itemTimeticks:   ValueTimeticks,
+	itemBoolean:     ValueBoolean,
+	itemIpv4address: ValueIpv4address,
+	itemBitset:      ValueBitset,
+	itemOid:         ValueOid,
+}
 
-	printStmt.exprn, err = parser.parseStrExpression()
-	if err != nil {
-		return nil, err
-	}
-	err = parser.match(itemNewLine, "print statement")
-	if err != nil {
-		return nil, err
+// Grammar
+// <procedures> ::= {<procedure> | <function>}
+// <procedure> ::= proc <identifier> ( [<param> {, <param>}] ) \n {<statement>} endproc \n
+// <function> ::= func <identifier> ( [<param> {, <param>}] ) : <type> \n {<statement>} endfunc \n
+// <param> ::= <identifier> : <type>
+//
+// Procedures and functions must be defined before they are called but can call themselves.
+// Functions return a value with return and can't change global variables.
+func (parser *Parser) parseProcedures() (procs map[string]*Procedure, err error) {
+	procs = make(map[string]*Procedure)
+	parser.procs = procs
+
+	for {
+		item := parser.peek()
+		if item.typ != itemProc && item.typ != itemFunc {
+			return procs, nil
+		}
+		parser.nextItem()
+		context := "procedure"
+		endItemTyp := itemEndProc
+		if item.typ == itemFunc {
+			context = "function"
+			endItemTyp = itemEndFunc
+		}
+
+		idItem, err := parser.matchItem(itemIdentifier, context)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := procs[idItem.val]; ok {
+			return nil, parser.errorf("Redefinition of procedure: %s", idItem.val)
+		}
+		if parser.lookupType(idItem.val) != ValueNone {
+			return nil, parser.errorf("Procedure has the name of a variable: %s", idItem.val)
+		}
+		proc := new(Procedure)
+		proc.name = idItem.val
+		proc.returnType = ValueNone
+
+		proc.params, err = parser.parseParams()
+		if err != nil {
+			return nil, err
+		}
+		if item.typ == itemFunc {
+			err = parser.match(itemColon, context)
+			if err != nil {
+				return nil, err
+			}
+			typeItem := parser.nextItem()
+			valueType, ok := paramTypes[typeItem.typ]
+			if !ok {
+				return nil, parser.errorf("Invalid return type for function %s", proc.name)
+			}
+			proc.returnType = valueType
+		}
+		err = parser.match(itemNewLine, context)
+		if err != nil {
+			return nil, err
+		}
+
+		// register before the body for recursion
+		procs[proc.name] = proc
+		parser.proc = proc
+		parser.locals = make(map[string]*Type)
+		for _, param := range proc.params {
+			parser.locals[param.id] = param
+		}
+
+		proc.stmtList, err = parser.parseStatementList()
+		if err != nil {
+			return nil, err
+		}
+		parser.locals = nil
+		parser.proc = nil
+
+		err = parser.match(endItemTyp, context)
+		if err != nil {
+			return nil, err
+		}
+		err = parser.match(itemNewLine, context)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return printStmt, nil
 }
 
+// Grammar
+// <tasks> ::= {<task> | <every> | <after> | <at> | <handler>}
+// <task> ::= task <identifier> \n {<statement>} endtask \n
+// <every> ::= every <int-expression> <time-units> \n {<statement>} endevery \n
+// <after> ::= after <int-expression> <time-units> \n {<statement>} endafter \n
+// <at> ::= at <hour>:<minute>[:<second>] \n {<statement>} endat \n
 //
-// read identifier
-//
-func (parser *Parser) parseReadStatement() (readStmt *ReadStatement, err error) {
-	readStmt = new(ReadStatement)
+// Tasks run alongside the run block, sharing its variables, and are stopped when it ends.
+// An every block runs after each interval, an after block once after the delay
+// and an at block each day at the time (until they break).
+func (parser *Parser) parseTasks(prog *Program) (err error) {
+	parser.tasks = make(map[string]*Task)
+	handlers := make(map[string]bool) // e.g. "set x"
 
-	item := parser.nextItem()
-	id := item.val
-	typ, ok := parser.variables.types[id]
-	if !ok {
-		return nil, parser.errorf("Unable to read on undefined variable")
-	}
-	if typ.oid == "" {
-		return nil, parser.errorf("Unable to read on non OID variable")
-	}
-	if typ.snmpMode != SnmpModeReadWrite {
-		return nil, parser.errorf("Unable to read on non rw OID variable")
-	}
+	for {
+		task := new(Task)
+		var endItemTyp itemType
+		item := parser.peek()
+		switch item.typ {
+		case itemOn:
+			parser.nextItem()
+			handler, err := parser.parseHandler()
+			if err != nil {
+				return err
+			}
+			key := fmt.Sprintf("%v %s", handler.handlerType, handler.identifier)
+			if handlers[key] {
+				return parser.errorf("Redefinition of on %v handler for %s", handler.handlerType, handler.identifier)
+			}
+			handlers[key] = true
+			prog.handlers = append(prog.handlers, handler)
+			continue
+		case itemTask:
+			task.taskType = TaskNamed
+			endItemTyp = itemEndTask
+		case itemEvery:
+			task.taskType = TaskEvery
+			endItemTyp = itemEndEvery
+		case itemAfter:
+			task.taskType = TaskAfter
+			endItemTyp = itemEndAfter
+		case itemAt:
+			task.taskType = TaskAt
+			endItemTyp = itemEndAt
+		default:
+			return nil
+		}
+		parser.nextItem()
+		task.lineNum = parser.token.line
+		context := item.val
+
+		switch task.taskType {
+		case TaskNamed:
+			idItem, err := parser.matchItem(itemIdentifier, context)
+			if err != nil {
+				return err
+			}
+			if _, ok := parser.tasks[idItem.val]; ok {
+				return parser.errorf("Redefinition of task: %s", idItem.val)
+			}
+			task.name = idItem.val
+			parser.tasks[task.name] = task
+		case TaskEvery, TaskAfter:
+			task.interval, err = parser.parseIntExpression()
+			if err != nil {
+				return err
+			}
+			task.units, err = parser.parseTimeUnits(context)
+			if err != nil {
+				return err
+			}
+		case TaskAt:
+			err = parser.parseTimeOfDay(task)
+			if err != nil {
+				return err
+			}
+		}
+		err = parser.match(itemNewLine, context)
+		if err != nil {
+			return err
+		}
 
-	readStmt.identifier = id
+		parser.task = task
+		task.stmtList, err = parser.parseStatementList()
+		parser.task = nil
+		if err != nil {
+			return err
+		}
+		err = parser.match(endItemTyp, context)
+		if err != nil {
+			return err
+		}
+		err = parser.match(itemNewLine, context)
+		if err != nil {
+			return err
+		}
 
-	err = parser.match(itemNewLine, "read")
-	if err != nil {
-		return nil, err
+		prog.tasks = append(prog.tasks, task)
 	}
+}
 
-	return readStmt, nil
+// checkTaskWaits checks each wait for a task names a task other than the one waiting
+func (parser *Parser) checkTaskWaits() error {
+	for _, wait := range parser.taskWaits {
+		if _, ok := parser.tasks[wait.taskName]; !ok {
+			return parser.errorAtf(wait.lineNum, "Wait for unknown task: %s", wait.taskName)
+		}
+		if wait.waiter != nil && wait.waiter.name == wait.taskName {
+			return parser.errorAtf(wait.lineNum, "Task %s can not wait for itself", wait.taskName)
+		}
+	}
+	return nil
 }
 
-func (parser *Parser) parseSleepStatement() (sleepStmt *SleepStatement, err error) {
-	sleepStmt = new(SleepStatement)
+// Grammar
+// <handler> ::= on set <identifier> \n {<statement>} endon \n |
+//               on get <identifier> \n {<statement>} endon \n
+//
+// An on set handler runs after a manager sets the rw variable, with the locals
+// old and new holding its values and requester the manager's address.
+// An on get handler runs before the variable's value is given to a manager, with requester.
+func (parser *Parser) parseHandler() (handler *Handler, err error) {
+	handler = new(Handler)
+	handler.lineNum = parser.token.line
+
+	// set and get are not keywords as they are common identifiers
+	item := parser.nextItem()
+	switch {
+	case item.typ == itemIdentifier && item.val == "set":
+		handler.handlerType = HandlerSet
+	case item.typ == itemIdentifier && item.val == "get":
+		handler.handlerType = HandlerGet
+	default:
+		return nil, parser.errorf("Expecting set or get after on but got \"%v\"", item.val)
+	}
+	context := "on " + item.val
 
-	sleepStmt.exprn, err = parser.parseIntExpression()
+	idItem, err := parser.matchItem(itemIdentifier, context)
+	if err != nil {
+		return nil, err
+	}
+	typ, ok := parser.variables.types[idItem.val]
+	if !ok {
+		return nil, parser.errorf("On %v of undefined variable: %s", handler.handlerType, idItem.val)
+	}
+	switch {
+	case handler.handlerType == HandlerSet && typ.snmpMode != SnmpModeReadWrite:
+		return nil, parser.errorf("On set of non rw OID variable: %s", idItem.val)
+	case typ.oid == "":
+		return nil, parser.errorf("On get of non OID variable: %s", idItem.val)
+	}
+	handler.identifier = idItem.val
+	err = parser.match(itemNewLine, context)
 	if err != nil {
 		return nil, err
 	}
 
-	item := parser.nextItem()
-	switch item.typ {
-	case itemSecs:
-		sleepStmt.units = TimeSecs
-	case itemMillis:
-		sleepStmt.units = TimeMillis
-	default:
-		return nil, parser.errorf("Expecting time units in sleep statement but got \"%v\"", item.typ)
+	handler.locals = []*Type{{id: handlerRequesterId, valueType: ValueString, lineNum: handler.lineNum}}
+	if handler.handlerType == HandlerSet {
+		handler.locals = append(handler.locals,
+			&Type{id: handlerOldId, valueType: typ.valueType, lineNum: handler.lineNum},
+			&Type{id: handlerNewId, valueType: typ.valueType, lineNum: handler.lineNum})
+	}
+	parser.locals = make(map[string]*Type)
+	for _, local := range handler.locals {
+		parser.locals[local.id] = local
 	}
+	handler.stmtList, err = parser.parseStatementList()
+	if err != nil {
+		return nil, err
+	}
+	parser.locals = nil
 
-	err = parser.match(itemNewLine, "sleep statement")
+	err = parser.match(itemEndOn, context)
 	if err != nil {
 		return nil, err
 	}
-	return sleepStmt, nil
+	err = parser.match(itemNewLine, context)
+	if err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// <hour>:<minute>[:<second>] on the 24 hour clock
+func (parser *Parser) parseTimeOfDay(task *Task) (err error) {
+	var fields []int
+	maxFields := []int{23, 59, 59}
+	for {
+		item, err := parser.matchItem(itemIntegerLiteral, "time of day")
+		if err != nil {
+			return err
+		}
+		x, err := strconv.Atoi(item.val)
+		if err != nil || x < 0 || x > maxFields[len(fields)] {
+			return parser.errorf("Invalid time of day (expecting hour 0-23, minute and second 0-59)")
+		}
+		fields = append(fields, x)
+		if len(fields) == 3 || parser.peek().typ != itemColon {
+			break
+		}
+		parser.nextItem()
+	}
+	if len(fields) < 2 {
+		return parser.errorf("Expecting time of day as hour:minute[:second]")
+	}
+	task.atHour, task.atMinute = fields[0], fields[1]
+	if len(fields) == 3 {
+		task.atSecond = fields[2]
+	}
+	return nil
+}
+
+// ( [<param> {, <param>}] )
+func (parser *Parser) parseParams() (params []*Type, err error) {
+	err = parser.match(itemLeftParen, "parameters")
+	if err != nil {
+		return nil, err
+	}
+	if parser.peek().typ == itemRightParen {
+		parser.nextItem()
+		return params, nil
+	}
+
+	ids := make(map[string]bool)
+	for {
+		idItem, err := parser.matchItem(itemIdentifier, "parameters")
+		if err != nil {
+			return nil, err
+		}
+		if ids[idItem.val] {
+			return nil, parser.errorf("Duplicate parameter: %s", idItem.val)
+		}
+		ids[idItem.val] = true
+
+		err = parser.match(itemColon, "parameters")
+		if err != nil {
+			return nil, err
+		}
+		typeItem := parser.nextItem()
+		valueType, ok := paramTypes[typeItem.typ]
+		if !ok {
+			return nil, parser.errorf("Invalid type for parameter %s", idItem.val)
+		}
+		params = append(params, &Type{id: idItem.val, valueType: valueType, lineNum: idItem.line})
+
+		item := parser.nextItem()
+		switch item.typ {
+		case itemComma:
+		case itemRightParen:
+			return params, nil
+		default:
+			return nil, parser.errorf("Expecting , or ) in parameters but got \"%v\"", item.typ)
+		}
+	}
+}
+
+//
+// call <identifier> ( [<expression> {, <expression>}] )
+//
+func (parser *Parser) parseCallStatement() (callStmt *CallStatement, err error) {
+	callStmt = new(CallStatement)
+
+	idItem, err := parser.matchItem(itemIdentifier, "call")
+	if err != nil {
+		return nil, err
+	}
+	proc, ok := parser.procs[idItem.val]
+	if !ok {
+		return nil, parser.errorf("Call of undefined procedure: %s", idItem.val)
+	}
+	if proc.returnType != ValueNone {
+		return nil, parser.errorf("Call of function %s (use it in an expression)", idItem.val)
+	}
+	callStmt.call, err = parser.parseCallArgs(proc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = parser.match(itemNewLine, "call")
+	if err != nil {
+		return nil, err
+	}
+	return callStmt, nil
+}
+
+// parse the arguments of a call checking them against the parameters
+func (parser *Parser) parseCallArgs(proc *Procedure) (call *Call, err error) {
+	call = new(Call)
+	call.name = proc.name
+	call.proc = proc
+	call.lineNum = parser.token.line
+
+	paramTypes := make([]ValueType, len(proc.params))
+	for i, param := range proc.params {
+		paramTypes[i] = param.valueType
+	}
+	call.args, err = parser.parseArgs(proc.name, paramTypes)
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// parseArgs parses the bracketed arguments of a function or builtin of the parameter types
+func (parser *Parser) parseArgs(name string, paramTypes []ValueType) (args []*Expression, err error) {
+	err = parser.match(itemLeftParen, "call arguments")
+	if err != nil {
+		return nil, err
+	}
+	for i, paramType := range paramTypes {
+		if paramType == anyValues {
+			// the rest of the arguments are of any number and type
+			for parser.peek().typ != itemRightParen {
+				if len(args) > 0 {
+					err = parser.match(itemComma, "call arguments")
+					if err != nil {
+						return nil, err
+					}
+				}
+				arg, err := parser.parseAnyExpression()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+			}
+			break
+		}
+		if i > 0 {
+			if parser.peek().typ == itemRightParen {
+				parser.nextItem()
+				return nil, parser.errorf("Wrong number of arguments calling %s (expecting %d)", name, len(paramTypes))
+			}
+			err = parser.match(itemComma, "call arguments")
+			if err != nil {
+				return nil, err
+			}
+		}
+		arg, err := parser.parseTypedExpression(paramType)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	item := parser.nextItem()
+	if item.typ != itemRightParen {
+		return nil, parser.errorf("Wrong number of arguments calling %s (expecting %d)", name, len(paramTypes))
+	}
+	return args, nil
+}
+
+//
+// return [<expression>]
+//
+// The expression is required in a function and not allowed in a procedure.
+func (parser *Parser) parseReturnStatement() (returnStmt *ReturnStatement, err error) {
+	returnStmt = new(ReturnStatement)
+
+	switch {
+	case parser.proc == nil:
+		return nil, parser.errorf("Return outside of a procedure or function")
+	case parser.inFunc():
+		returnStmt.exprn, err = parser.parseTypedExpression(parser.proc.returnType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = parser.match(itemNewLine, "return")
+	if err != nil {
+		return nil, err
+	}
+	return returnStmt, nil
+}
+
+//
+// wait <task-identifier> [<timeout>] |
+// wait poll <identifier> [times <int-expression>] [<timeout>] |
+// wait until <bool-expression> [<timeout>]
+//
+func (parser *Parser) parseWaitStatement() (waitStmt *WaitStatement, err error) {
+	waitStmt = new(WaitStatement)
+
+	idItem, err := parser.matchItem(itemIdentifier, "wait")
+	if err != nil {
+		return nil, err
+	}
+	// poll is not a keyword so it can still name a task
+	if idItem.val == "poll" && parser.peek().typ == itemIdentifier {
+		waitStmt.waitType = WaitPoll
+		varItem := parser.nextItem()
+		typ, ok := parser.variables.types[varItem.val]
+		if !ok || typ.oid == "" {
+			return nil, parser.errorf("Wait poll of non OID variable: %s", varItem.val)
+		}
+		waitStmt.identifier = varItem.val
+		if parser.peek().typ == itemLoopTimes {
+			parser.nextItem()
+			waitStmt.times, err = parser.parseIntExpression()
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if idItem.val == "until" && parser.peek().typ != itemNewLine && !parser.peekWord("timeout") {
+		// likewise until
+		waitStmt.waitType = WaitUntil
+		waitStmt.condition, err = parser.parseBoolExpression()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// tasks may be defined after procedures and other tasks waiting for them
+		parser.taskWaits = append(parser.taskWaits, TaskWait{idItem.val, idItem.line, parser.task})
+		waitStmt.waitType = WaitTask
+		waitStmt.taskName = idItem.val
+	}
+
+	waitStmt.timeout, err = parser.parseTimeout("wait")
+	if err != nil {
+		return nil, err
+	}
+
+	err = parser.match(itemNewLine, "wait")
+	if err != nil {
+		return nil, err
+	}
+	return waitStmt, nil
+}
+
+func (parser *Parser) parsePrintStatement() (printStmt *PrintStatement, err error) {
+	printStmt = new(PrintStatement)
+
+	printStmt.exprn, err = parser.parseStrExpression()
+	if err != nil {
+		return nil, err
+	}
+	err = parser.match(itemNewLine, "print statement")
+	if err != nil {
+		return nil, err
+	}
+	return printStmt, nil
+}
+
+//
+// read identifier [<timeout>]
+//
+func (parser *Parser) parseReadStatement() (readStmt *ReadStatement, err error) {
+	readStmt = new(ReadStatement)
+
+	readStmt.identifier, err = parser.parseReadIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	readStmt.timeout, err = parser.parseTimeout("read")
+	if err != nil {
+		return nil, err
+	}
+
+	err = parser.match(itemNewLine, "read")
+	if err != nil {
+		return nil, err
+	}
+
+	return readStmt, nil
+}
+
+// parseReadIdentifier parses a variable which a manager can set
+func (parser *Parser) parseReadIdentifier() (id string, err error) {
+	item := parser.nextItem()
+	id = item.val
+	typ, ok := parser.variables.types[id]
+	if !ok {
+		return "", parser.errorf("Unable to read on undefined variable")
+	}
+	if typ.oid == "" {
+		return "", parser.errorf("Unable to read on non OID variable")
+	}
+	if typ.snmpMode == SnmpModeRead {
+		return "", parser.errorf("Unable to read on non rw or rwb OID variable")
+	}
+	return id, nil
+}
+
+// Grammar
+// <select> ::= select \n {case read <identifier> \n {<statement>}}
+//    [<timeout> \n {<statement>}] endselect \n
+//
+func (parser *Parser) parseSelectStatement() (selectStmt *SelectStatement, err error) {
+	selectStmt = new(SelectStatement)
+
+	err = parser.match(itemNewLine, "select")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case parser.peekWord("case"):
+			if selectStmt.timeout != nil {
+				return nil, parser.errorf("Case after timeout in select statement")
+			}
+			parser.nextItem()
+			selectCase, err := parser.parseSelectCase(selectStmt)
+			if err != nil {
+				return nil, err
+			}
+			selectStmt.cases = append(selectStmt.cases, selectCase)
+		case parser.peekWord("timeout"):
+			if selectStmt.timeout != nil {
+				return nil, parser.errorf("Second timeout in select statement")
+			}
+			selectStmt.timeout, err = parser.parseTimeout("select")
+			if err != nil {
+				return nil, err
+			}
+			err = parser.match(itemNewLine, "select timeout")
+			if err != nil {
+				return nil, err
+			}
+			selectStmt.timeoutStmtList, err = parser.parseCaseStatementList()
+			if err != nil {
+				return nil, err
+			}
+		case parser.peek().typ == itemEndSelect:
+			parser.nextItem()
+			if len(selectStmt.cases) == 0 {
+				return nil, parser.errorf("Select statement without a case")
+			}
+			err = parser.match(itemNewLine, "select")
+			if err != nil {
+				return nil, err
+			}
+			return selectStmt, nil
+		default:
+			parser.nextItem()
+			return nil, parser.errorf("Bad token in select statement")
+		}
+	}
+}
+
+// grammar:
+//    case read <identifier> \n {<statement>}
+//
+func (parser *Parser) parseSelectCase(selectStmt *SelectStatement) (selectCase *SelectCase, err error) {
+	selectCase = new(SelectCase)
+
+	err = parser.match(itemRead, "case")
+	if err != nil {
+		return nil, err
+	}
+	selectCase.identifier, err = parser.parseReadIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	for _, other := range selectStmt.cases {
+		if other.identifier == selectCase.identifier {
+			return nil, parser.errorf("Second case for %s in select statement", selectCase.identifier)
+		}
+	}
+	err = parser.match(itemNewLine, "case")
+	if err != nil {
+		return nil, err
+	}
+	selectCase.stmtList, err = parser.parseCaseStatementList()
+	if err != nil {
+		return nil, err
+	}
+	return selectCase, nil
+}
+
+// parseCaseStatementList parses the statements of a case which also end at the next case or a timeout,
+// so case and timeout can't start an assignment directly in a case
+func (parser *Parser) parseCaseStatementList() ([]*Statement, error) {
+	var stmtList []*Statement
+	for !parser.peekWord("case") && !parser.peekWord("timeout") {
+		if isStmtListEndKeyword(parser.peek()) {
+			return stmtList, nil
+		}
+		stmt, err := parser.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmtList = append(stmtList, stmt)
+	}
+	return stmtList, nil
+}
+
+//
+// dump <string-expression>
+//
+func (parser *Parser) parseDumpStatement() (dumpStmt *DumpStatement, err error) {
+	dumpStmt = new(DumpStatement)
+
+	dumpStmt.filename, err = parser.parseStrExpression()
+	if err != nil {
+		return nil, err
+	}
+	err = parser.match(itemNewLine, "dump statement")
+	if err != nil {
+		return nil, err
+	}
+	return dumpStmt, nil
+}
+
+func (parser *Parser) parseSleepStatement() (sleepStmt *SleepStatement, err error) {
+	sleepStmt = new(SleepStatement)
+
+	sleepStmt.exprn, err = parser.parseIntExpression()
+	if err != nil {
+		return nil, err
+	}
+	sleepStmt.units, err = parser.parseTimeUnits("sleep statement")
+	if err != nil {
+		return nil, err
+	}
+
+	err = parser.match(itemNewLine, "sleep statement")
+	if err != nil {
+		return nil, err
+	}
+	return sleepStmt, nil
+}
+
+// <timeout> ::= timeout <int-expression> <time-units> [timedout <bool-identifier>]
+//
+// The boolean variable is set to whether the statement timed out.
+func (parser *Parser) parseTimeout(context string) (timeout *Timeout, err error) {
+	if !parser.peekWord("timeout") {
+		return nil, nil
+	}
+	parser.nextItem()
+
+	timeout = new(Timeout)
+	timeout.exprn, err = parser.parseIntExpression()
+	if err != nil {
+		return nil, err
+	}
+	timeout.units, err = parser.parseTimeUnits(context + " timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.peekWord("timedout") {
+		parser.nextItem()
+		idItem, err := parser.matchItem(itemIdentifier, context+" timedout")
+		if err != nil {
+			return nil, err
+		}
+		if parser.lookupType(idItem.val) != ValueBoolean {
+			return nil, parser.errorf("Timed out variable is not boolean: %s", idItem.val)
+		}
+		timeout.timedOutId = idItem.val
+	}
+	return timeout, nil
+}
+
+// <time-units> ::= secs | msecs
+func (parser *Parser) parseTimeUnits(context string) (units TimeUnit, err error) {
+	item := parser.nextItem()
+	switch item.typ {
+	case itemSecs:
+		return TimeSecs, nil
+	case itemMillis:
+		return TimeMillis, nil
+	}
+	return 0, parser.errorf("Expecting time units in %s but got \"%v\"", context, item.typ)
 }
 
 // Note: other parsers use panic/recover instead of returning an error
@@ -1040,6 +2118,53 @@ func (parser *Parser) parseLoopStatement() (loopStmt *LoopStatement, err error)
 	return loopStmt, nil
 }
 
+//
+// for <identifier> in <bitset-expression> \n {<statement>} endfor \n
+//
+// The integer variable is set to each bit of the bitset in turn from the lowest.
+func (parser *Parser) parseForStatement() (forStmt *ForStatement, err error) {
+	forStmt = new(ForStatement)
+
+	idItem, err := parser.matchItem(itemIdentifier, "for")
+	if err != nil {
+		return nil, err
+	}
+	if parser.lookupType(idItem.val) != ValueInteger {
+		return nil, parser.errorf("Variable of for statement is not an integer: %s", idItem.val)
+	}
+	if _, ok := parser.locals[idItem.val]; parser.inFunc() && !ok {
+		return nil, parser.errorf("Function %s can not assign to global variable %s", parser.proc.name, idItem.val)
+	}
+	forStmt.identifier = idItem.val
+
+	// "in" is only a keyword here
+	inItem := parser.nextItem()
+	if inItem.typ != itemIdentifier || inItem.val != "in" {
+		return nil, parser.errorf("Expecting in after variable of for statement but got %v", inItem)
+	}
+	forStmt.bitsetExpression, err = parser.parseBitsetExpression()
+	if err != nil {
+		return nil, err
+	}
+	err = parser.match(itemNewLine, "for")
+	if err != nil {
+		return nil, err
+	}
+	forStmt.stmtList, err = parser.parseStatementList()
+	if err != nil {
+		return nil, err
+	}
+	err = parser.match(itemEndFor, "for")
+	if err != nil {
+		return nil, err
+	}
+	err = parser.match(itemNewLine, "for")
+	if err != nil {
+		return nil, err
+	}
+	return forStmt, nil
+}
+
 // Grammar
 // <if> ::= if <bool-expression> \n {<statement>}
 //    {elseif <bool-expression> \n {<statement>}} [else \n {<statement>}] endif \n
@@ -1110,9 +2235,8 @@ func (parser *Parser) parseElseIf() (elseIf *ElseIf, err error) {
 	return elseIf, nil
 }
 
-func (parser *Parser) parseAssignment() (assign *AssignmentStatement, err error) {
+func (parser *Parser) parseAssignment(idItem item) (assign *AssignmentStatement, err error) {
 	assign = new(AssignmentStatement)
-	idItem := parser.nextItem()
 	assign.identifier = idItem.val
 	idType := parser.lookupType(assign.identifier)
 
@@ -1128,6 +2252,9 @@ func (parser *Parser) parseAssignment() (assign *AssignmentStatement, err error)
 	case ValueNone:
 		return nil, parser.errorf("Assignment to undeclared variable: %s", idItem.val)
 	}
+	if _, ok := parser.locals[assign.identifier]; parser.inFunc() && !ok {
+		return nil, parser.errorf("Function %s can not assign to global variable %s", parser.proc.name, idItem.val)
+	}
 
 	err = parser.match(itemEquals, "Assignment")
 	if err != nil {
@@ -1155,64 +2282,72 @@ func (parser *Parser) parseAssignment() (assign *AssignmentStatement, err error)
 			assign.exprn.exprnType = ExprnBytes
 			assign.exprn.bytesExpression = bytesExprn
 		}
-	case ValueBoolean:
-		boolExprn, err := parser.parseBoolExpression()
+	default:
+		assign.exprn, err = parser.parseTypedExpression(idType)
 		if err != nil {
 			return nil, err
 		}
-		assign.exprn = new(Expression)
-		assign.exprn.exprnType = ExprnBoolean
-		assign.exprn.boolExpression = boolExprn
+	}
+
+	err = parser.match(itemNewLine, "assignment")
+	if err != nil {
+		return nil, err
+	}
+
+	return assign, nil
+}
+
+// parse an expression giving a value of the type
+func (parser *Parser) parseTypedExpression(valueType ValueType) (exprn *Expression, err error) {
+	exprn = new(Expression)
+	switch valueType {
+	case ValueBoolean:
+		exprn.exprnType = ExprnBoolean
+		exprn.boolExpression, err = parser.parseBoolExpression()
 	case ValueInteger, ValueCounter, ValueTimeticks, ValueGuage:
-		intExprn, err := parser.parseIntExpression()
-		if err != nil {
-			return nil, err
-		}
-		assign.exprn = new(Expression)
-		assign.exprn.exprnType = ExprnInteger
-		assign.exprn.intExpression = intExprn
+		exprn.exprnType = ExprnInteger
+		exprn.intExpression, err = parser.parseIntExpression()
 	case ValueString:
-		strExprn, err := parser.parseStrExpression()
-		if err != nil {
-			return nil, err
-		}
-		assign.exprn = new(Expression)
-		assign.exprn.exprnType = ExprnString
-		assign.exprn.stringExpression = strExprn
+		exprn.exprnType = ExprnString
+		exprn.stringExpression, err = parser.parseStrExpression()
 	case ValueBitset:
-		bitsetExprn, err := parser.parseBitsetExpression()
-		if err != nil {
-			return nil, err
-		}
-		assign.exprn = new(Expression)
-		assign.exprn.exprnType = ExprnBitset
-		assign.exprn.bitsetExpression = bitsetExprn
+		exprn.exprnType = ExprnBitset
+		exprn.bitsetExpression, err = parser.parseBitsetExpression()
 	case ValueOid:
-		oidExprn, err := parser.parseOidExpression()
-		if err != nil {
-			return nil, err
-		}
-		assign.exprn = new(Expression)
-		assign.exprn.exprnType = ExprnOid
-		assign.exprn.oidExpression = oidExprn
+		exprn.exprnType = ExprnOid
+		exprn.oidExpression, err = parser.parseOidExpression()
 	case ValueIpv4address:
-		addrExprn, err := parser.parseAddrExpression()
-		if err != nil {
-			return nil, err
-		}
-		assign.exprn = new(Expression)
-		assign.exprn.exprnType = ExprnAddr
-		assign.exprn.addrExpression = addrExprn
+		exprn.exprnType = ExprnAddr
+		exprn.addrExpression, err = parser.parseAddrExpression()
+	case ValueBytes:
+		exprn.exprnType = ExprnBytes
+		exprn.bytesExpression, err = parser.parseBytesExpression()
 	default:
-		return nil, parser.errorf("Assignment to undeclared variable: %s", idItem.val)
+		return nil, parser.errorf("Expression of unknown type")
 	}
-
-	err = parser.match(itemNewLine, "assignment")
 	if err != nil {
 		return nil, err
 	}
+	return exprn, nil
+}
 
-	return assign, nil
+// parseAnyExpression parses an expression of the type its first token has
+func (parser *Parser) parseAnyExpression() (exprn *Expression, err error) {
+	if parser.peek().typ == itemIf {
+		parser.nextItem()
+		exprn = &Expression{exprnType: ExprnConditional}
+		exprn.conditional, err = parser.parseConditional(ValueNone)
+		if err != nil {
+			return nil, err
+		}
+		return exprn, nil
+	}
+	valueType := parser.peekValueType()
+	if valueType == ValueNone {
+		parser.nextItem()
+		return nil, parser.errorf("Can not tell the type of expression")
+	}
+	return parser.parseTypedExpression(valueType)
 }
 
 func (parser *Parser) parseBytesExpression() (bytesExprn *BytesExpression, err error) {
@@ -1283,7 +2418,7 @@ func (parser *Parser) parseBitsetExpression() (bitsetExprn *BitsetExpression, er
 	bitsetExprn = new(BitsetExpression)
 
 	// process 1st term
-	bitsetTerm, err := parser.parseBitsetTerm()
+	bitsetTerm, err := parser.parseBitsetProduct()
 	if err != nil {
 		return nil, err
 	}
@@ -1302,7 +2437,7 @@ loop:
 			break loop
 		}
 		parser.nextItem()
-		bitsetTerm, err := parser.parseBitsetTerm()
+		bitsetTerm, err := parser.parseBitsetProduct()
 		if err != nil {
 			return nil, err
 		}
@@ -1316,12 +2451,41 @@ loop:
 	return bitsetExprn, nil
 }
 
+// parseBitsetProduct parses the intersection of bitset terms, a * b
+func (parser *Parser) parseBitsetProduct() (bitsetTerm *BitsetTerm, err error) {
+	bitsetTerm, err = parser.parseBitsetTerm()
+	if err != nil {
+		return nil, err
+	}
+	if parser.peek().typ != itemTimes {
+		return bitsetTerm, nil
+	}
+	product := &BitsetTerm{bitsetTermType: BitsetTermIntersection, intersectTerms: []*BitsetTerm{bitsetTerm}}
+	for parser.peek().typ == itemTimes {
+		parser.nextItem()
+		bitsetTerm, err = parser.parseBitsetTerm()
+		if err != nil {
+			return nil, err
+		}
+		product.intersectTerms = append(product.intersectTerms, bitsetTerm)
+	}
+	return product, nil
+}
+
 func (parser *Parser) parseBitsetTerm() (bitsetTerm *BitsetTerm, err error) {
 	bitsetTerm = new(BitsetTerm)
 
 	item := parser.nextItem()
 	switch item.typ {
 	case itemIdentifier:
+		if fn := parser.lookupFunc(item.val, ValueBitset); fn != nil {
+			bitsetTerm.bitsetTermType = BitsetTermCall
+			bitsetTerm.call, err = parser.parseCallArgs(fn)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		if parser.lookupType(item.val) != ValueBitset {
 			return nil, parser.errorf("Not bitset variable in bitset expression")
 		}
@@ -1344,7 +2508,21 @@ func (parser *Parser) parseBitsetTerm() (bitsetTerm *BitsetTerm, err error) {
 		if err != nil {
 			return nil, err
 		}
+	case itemIf:
+		bitsetTerm.bitsetTermType = BitsetTermConditional
+		bitsetTerm.conditional, err = parser.parseConditional(ValueBitset)
+		if err != nil {
+			return nil, err
+		}
 	default:
+		if builtin, ok := builtins[item.typ]; ok && builtin.returnType == ValueBitset {
+			bitsetTerm.bitsetTermType = BitsetTermBuiltin
+			bitsetTerm.builtin, err = parser.parseBuiltinCall(builtin)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		return nil, parser.errorf("Invalid bitset term")
 	}
 	return bitsetTerm, nil
@@ -1465,7 +2643,45 @@ func (parser *Parser) parseOidExpression() (oidExprn *OidExpression, err error)
 	return oidExprn, nil
 }
 
+// Integer operators from the lowest precedence, above them are + and - then *, / and mod
+var intOperatorLevels = []map[itemType]IntOperationType{
+	{itemBitOr: IntOpBitOr},
+	{itemBitXor: IntOpBitXor},
+	{itemBitAnd: IntOpBitAnd},
+	{itemShiftLeft: IntOpShiftLeft, itemShiftRight: IntOpShiftRight},
+}
+
+//<int-expression>::=<int-operation>
+//<int-operation>::=<int-sum>{<int-operator><int-sum>}
+//                  using the precedence of the operator levels
 func (parser *Parser) parseIntExpression() (intExprn *IntExpression, err error) {
+	return parser.parseIntOperation(0)
+}
+
+func (parser *Parser) parseIntOperation(level int) (intExprn *IntExpression, err error) {
+	if level == len(intOperatorLevels) {
+		return parser.parseIntSum()
+	}
+	intExprn, err = parser.parseIntOperation(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		intOp, ok := intOperatorLevels[level][parser.peek().typ]
+		if !ok {
+			return intExprn, nil
+		}
+		parser.nextItem()
+		lineNum := parser.token.line
+		rhs, err := parser.parseIntOperation(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		intExprn = &IntExpression{intOp: intOp, lhs: intExprn, rhs: rhs, lineNum: lineNum}
+	}
+}
+
+func (parser *Parser) parseIntSum() (intExprn *IntExpression, err error) {
 	intExprn = new(IntExpression)
 
 	// process 1st term
@@ -1512,31 +2728,44 @@ func (parser *Parser) parseIntTerm() (intTerm *IntTerm, err error) {
 	intTerm.timesFactors = append(intTerm.timesFactors, intFactor)
 
 	// optionally process others
-	var usingTimes bool
-loop:
 	for {
-		switch parser.peek().typ {
-		case itemTimes:
-			usingTimes = true
-		case itemDivide:
-			usingTimes = false
-		default:
-			break loop
+		opType := parser.peek().typ
+		if opType != itemTimes && opType != itemDivide && opType != itemMod {
+			break
 		}
 		parser.nextItem()
+		intTerm.lineNum = parser.token.line
 		intFactor, err := parser.parseIntFactor()
 		if err != nil {
 			return nil, err
 		}
-		if usingTimes {
+		if opType != itemMod && len(intTerm.modFactors) > 0 {
+			// the mod is taken before multiplying or dividing again
+			intTerm = bracketIntTerm(intTerm)
+		}
+		switch opType {
+		case itemTimes:
 			intTerm.timesFactors = append(intTerm.timesFactors, intFactor)
-		} else {
+		case itemDivide:
 			intTerm.divideFactors = append(intTerm.divideFactors, intFactor)
+		case itemMod:
+			intTerm.modFactors = append(intTerm.modFactors, intFactor)
 		}
 	}
 	return intTerm, nil
 }
 
+// bracketIntTerm makes a term whose one factor is the bracketed term
+func bracketIntTerm(intTerm *IntTerm) *IntTerm {
+	return &IntTerm{
+		timesFactors: []*IntFactor{{
+			intFactorType:  IntFactorBracket,
+			bracketedExprn: &IntExpression{plusTerms: []*IntTerm{intTerm}},
+		}},
+		lineNum: intTerm.lineNum,
+	}
+}
+
 //<bool-term>::=<bool-factor>{<and><bool-factor>}
 func (parser *Parser) parseBoolTerm() (boolTerm *BoolTerm, err error) {
 	boolTerm = new(BoolTerm)
@@ -1569,14 +2798,30 @@ func (parser *Parser) parseStrTerm() (strTerm *StringTerm, err error) {
 	item := parser.nextItem()
 	switch item.typ {
 	case itemIdentifier:
+		if fn := parser.lookupFunc(item.val, ValueString); fn != nil {
+			strTerm.strTermType = StringTermCall
+			strTerm.call, err = parser.parseCallArgs(fn)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		if parser.lookupType(item.val) != ValueString {
 			return nil, parser.errorf("Not string variable in string expression")
 		}
 		strTerm.strTermType = StringTermId
 		strTerm.identifier = item.val
 	case itemStringLiteral:
-		strTerm.strTermType = StringTermValue
-		strTerm.strVal = item.val
+		return parser.parseInterpolation(item.val)
+	case itemError:
+		// a string literal the lexer could not scan
+		return nil, parser.errorf("%s", item.val)
+	case itemIf:
+		strTerm.strTermType = StringTermConditional
+		strTerm.conditional, err = parser.parseConditional(ValueString)
+		if err != nil {
+			return nil, err
+		}
 	case itemLeftParen:
 		strTerm.strTermType = StringTermBracket
 		strTerm.bracketedExprn, err = parser.parseStrExpression()
@@ -1672,11 +2917,69 @@ func (parser *Parser) parseStrTerm() (strTerm *StringTerm, err error) {
 			return nil, err
 		}
 	default:
+		if builtin, ok := builtins[item.typ]; ok && builtin.returnType == ValueString {
+			strTerm.strTermType = StringTermBuiltin
+			strTerm.builtin, err = parser.parseBuiltinCall(builtin)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		return nil, parser.errorf("Invalid string term")
 	}
 	return strTerm, nil
 }
 
+//
+// "text ${id} text"
+//
+// A variable of any type in ${} is replaced by its value as a string, $$ is a $.
+func (parser *Parser) parseInterpolation(str string) (strTerm *StringTerm, err error) {
+	strExprn := new(StringExpression)
+	literal := ""
+	for {
+		i := strings.IndexByte(str, '$')
+		if i < 0 || i == len(str)-1 {
+			literal += str
+			break
+		}
+		literal += str[:i]
+		switch str[i+1] {
+		case '$':
+			literal += "$"
+			str = str[i+2:]
+			continue
+		case '{':
+		default:
+			literal += "$"
+			str = str[i+1:]
+			continue
+		}
+		end := strings.IndexByte(str[i:], '}')
+		if end < 0 {
+			return nil, parser.errorf("Missing } in string")
+		}
+		id := str[i+2 : i+end]
+		if parser.lookupType(id) == ValueNone {
+			return nil, parser.errorf("Unknown variable in string: %s", id)
+		}
+		if literal != "" {
+			strExprn.addTerms = append(strExprn.addTerms, &StringTerm{strTermType: StringTermValue, strVal: literal})
+			literal = ""
+		}
+		strExprn.addTerms = append(strExprn.addTerms, &StringTerm{strTermType: StringTermStringedId, identifier: id})
+		str = str[i+end+1:]
+	}
+
+	if len(strExprn.addTerms) == 0 {
+		return &StringTerm{strTermType: StringTermValue, strVal: literal}, nil
+	}
+	if literal != "" {
+		strExprn.addTerms = append(strExprn.addTerms, &StringTerm{strTermType: StringTermValue, strVal: literal})
+	}
+	return &StringTerm{strTermType: StringTermBracket, bracketedExprn: strExprn}, nil
+}
+
 func (parser *Parser) validateAddrStr(addrStr string) (err error) {
 	components := strings.Split(addrStr, ".")
 	if len(components) != 4 {
@@ -1697,6 +3000,14 @@ func (parser *Parser) parseAddrExpression() (addrExprn *AddrExpression, err erro
 	item := parser.nextItem()
 	switch item.typ {
 	case itemIdentifier:
+		if fn := parser.lookupFunc(item.val, ValueIpv4address); fn != nil {
+			addrExprn.addrExprnType = AddrExprnCall
+			addrExprn.call, err = parser.parseCallArgs(fn)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		if parser.lookupType(item.val) != ValueIpv4address {
 			return nil, parser.errorf("Not address variable in address expression")
 		}
@@ -1709,7 +3020,21 @@ func (parser *Parser) parseAddrExpression() (addrExprn *AddrExpression, err erro
 		}
 		addrExprn.addrExprnType = AddrExprnValue
 		addrExprn.addrVal = item.val
+	case itemIf:
+		addrExprn.addrExprnType = AddrExprnConditional
+		addrExprn.conditional, err = parser.parseConditional(ValueIpv4address)
+		if err != nil {
+			return nil, err
+		}
 	default:
+		if builtin, ok := builtins[item.typ]; ok && builtin.returnType == ValueIpv4address {
+			addrExprn.addrExprnType = AddrExprnBuiltin
+			addrExprn.builtin, err = parser.parseBuiltinCall(builtin)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		return nil, parser.errorf("Invalid address expression")
 	}
 	return addrExprn, nil
@@ -1721,6 +3046,14 @@ func (parser *Parser) parseOidTerm() (oidTerm *OidTerm, err error) {
 	item := parser.nextItem()
 	switch item.typ {
 	case itemIdentifier:
+		if fn := parser.lookupFunc(item.val, ValueOid); fn != nil {
+			oidTerm.oidTermType = OidTermCall
+			oidTerm.call, err = parser.parseCallArgs(fn)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		if parser.lookupType(item.val) != ValueOid {
 			return nil, parser.errorf("Not oid variable in oid expression")
 		}
@@ -1739,14 +3072,30 @@ func (parser *Parser) parseOidTerm() (oidTerm *OidTerm, err error) {
 		if err != nil {
 			return nil, err
 		}
+	case itemIf:
+		oidTerm.oidTermType = OidTermConditional
+		oidTerm.conditional, err = parser.parseConditional(ValueOid)
+		if err != nil {
+			return nil, err
+		}
 	default:
+		if builtin, ok := builtins[item.typ]; ok && builtin.returnType == ValueOid {
+			oidTerm.oidTermType = OidTermBuiltin
+			oidTerm.builtin, err = parser.parseBuiltinCall(builtin)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		return nil, parser.errorf("Invalid oid term")
 	}
 	return oidTerm, nil
 }
 
 //<bool-factor>::=<bool-constant>|<not><bool-factor>|(<bool-expression>)
-//                |<int-comparison>
+//                |<int-comparison>|<comparison>
+//<comparison>::=<expression><comparator><expression>
+//               where both expressions are of the same type
 func (parser *Parser) parseBoolFactor() (boolFactor *BoolFactor, err error) {
 	boolFactor = new(BoolFactor)
 
@@ -1756,24 +3105,24 @@ func (parser *Parser) parseBoolFactor() (boolFactor *BoolFactor, err error) {
 	case itemIdentifier:
 		// match on boolean or bitset variables only
 		id := item.val
-		if parser.lookupType(id) == ValueBoolean {
+		if fn := parser.lookupFunc(id, ValueBoolean); fn != nil {
+			match = true
+			parser.nextItem()
+			boolFactor.boolFactorType = BoolFactorCall
+			boolFactor.call, err = parser.parseCallArgs(fn)
+			if err != nil {
+				return nil, err
+			}
+		} else if parser.lookupType(id) == ValueBoolean {
 			match = true
 			parser.nextItem()
 			boolFactor.boolFactorType = BoolFactorId
 			boolFactor.boolIdentifier = id
 		} else if parser.lookupType(id) == ValueBitset {
 			match = true
-			parser.nextItem()
-			item = parser.nextItem()
-			if item.typ == itemContains {
-				boolFactor.boolFactorType = BoolFactorContains
-				boolFactor.bitsetId = id
-				boolFactor.bitsetElement, err = parser.parseIntExpression()
-				if err != nil {
-					return nil, parser.errorf("Missing bitset container int element")
-				}
-			} else {
-				return nil, parser.errorf("Bitset in boolean expression missing \"contains\"")
+			boolFactor, err = parser.parseBitsetFactor()
+			if err != nil {
+				return nil, err
 			}
 		}
 	case itemTrue:
@@ -1807,17 +3156,257 @@ func (parser *Parser) parseBoolFactor() (boolFactor *BoolFactor, err error) {
 		if err != nil {
 			return nil, err
 		}
+	case itemIf:
+		match = true
+		parser.nextItem()
+		boolFactor.boolFactorType = BoolFactorConditional
+		boolFactor.conditional, err = parser.parseConditional(ValueBoolean)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		if builtin, ok := builtins[item.typ]; ok && builtin.returnType == ValueBoolean {
+			match = true
+			parser.nextItem()
+			boolFactor.boolFactorType = BoolFactorBuiltin
+			boolFactor.builtin, err = parser.parseBuiltinCall(builtin)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	if !match {
-		boolFactor.boolFactorType = BoolFactorIntComparison
-		boolFactor.intComparison, err = parser.parseIntComparison()
+		switch valueType := parser.peekValueType(); valueType {
+		case ValueString:
+			boolFactor, err = parser.parseStringFactor()
+		case ValueBitset:
+			boolFactor, err = parser.parseBitsetFactor()
+		case ValueOid, ValueIpv4address:
+			boolFactor.boolFactorType = BoolFactorComparison
+			boolFactor.comparison, err = parser.parseComparison(valueType)
+		default:
+			boolFactor.boolFactorType = BoolFactorIntComparison
+			boolFactor.intComparison, err = parser.parseIntComparison()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return boolFactor, nil
+	}
+
+	switch boolFactor.boolFactorType {
+	case BoolFactorId, BoolFactorConst, BoolFactorCall, BoolFactorBracket, BoolFactorBuiltin:
+		if _, ok := comparators[parser.peek().typ]; ok {
+			return parser.parseBoolComparison(boolFactor)
+		}
+	}
+	return boolFactor, nil
+}
+
+// parseBitsetFactor parses a bitset containing an element or compared with another bitset
+func (parser *Parser) parseBitsetFactor() (boolFactor *BoolFactor, err error) {
+	boolFactor = new(BoolFactor)
+
+	lhs, err := parser.parseBitsetExpression()
+	if err != nil {
+		return nil, err
+	}
+	item := parser.peek()
+	if item.typ == itemContains {
+		if len(lhs.plusTerms) != 1 || len(lhs.minusTerms) != 0 || lhs.plusTerms[0].bitsetTermType != BitsetTermId {
+			return nil, parser.errorf("Bitset expression before \"contains\" is not a variable")
+		}
+		parser.nextItem()
+		boolFactor.boolFactorType = BoolFactorContains
+		boolFactor.bitsetId = lhs.plusTerms[0].identifier
+		boolFactor.bitsetElement, err = parser.parseIntExpression()
+		if err != nil {
+			return nil, parser.errorf("Missing bitset container int element")
+		}
+		return boolFactor, nil
+	}
+	if _, ok := comparators[item.typ]; !ok {
+		return nil, parser.errorf("Bitset in boolean expression missing \"contains\" or comparison")
+	}
+
+	boolFactor.boolFactorType = BoolFactorComparison
+	boolFactor.comparison = &Comparison{
+		valueType: ValueBitset,
+		lhs:       &Expression{exprnType: ExprnBitset, bitsetExpression: lhs},
+	}
+	boolFactor.comparison.comparator, boolFactor.comparison.rhs, err = parser.parseComparisonRhs(ValueBitset)
+	if err != nil {
+		return nil, err
+	}
+	return boolFactor, nil
+}
+
+// parseStringFactor parses a string containing another or compared with it
+func (parser *Parser) parseStringFactor() (boolFactor *BoolFactor, err error) {
+	boolFactor = new(BoolFactor)
+
+	lhs, err := parser.parseStrExpression()
+	if err != nil {
+		return nil, err
+	}
+	if parser.peek().typ == itemContains {
+		parser.nextItem()
+		boolFactor.boolFactorType = BoolFactorStrContains
+		boolFactor.strContainer = lhs
+		boolFactor.strElement, err = parser.parseStrExpression()
 		if err != nil {
 			return nil, err
 		}
+		return boolFactor, nil
+	}
+
+	boolFactor.boolFactorType = BoolFactorComparison
+	boolFactor.comparison = &Comparison{
+		valueType: ValueString,
+		lhs:       &Expression{exprnType: ExprnString, stringExpression: lhs},
+	}
+	boolFactor.comparison.comparator, boolFactor.comparison.rhs, err = parser.parseComparisonRhs(ValueString)
+	if err != nil {
+		return nil, err
+	}
+	return boolFactor, nil
+}
+
+// parseBoolComparison parses the rest of a comparison of the boolean factor
+// Booleans are only equal or not.
+func (parser *Parser) parseBoolComparison(lhs *BoolFactor) (boolFactor *BoolFactor, err error) {
+	item := parser.nextItem()
+	if item.typ != itemEquals && item.typ != itemNotEquals {
+		return nil, parser.errorf("Bad operator for boolean")
+	}
+	err = parser.checkComparable(ValueBoolean)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := parser.parseBoolFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	boolFactor = new(BoolFactor)
+	boolFactor.boolFactorType = BoolFactorComparison
+	boolFactor.comparison = &Comparison{
+		comparator: comparators[item.typ],
+		valueType:  ValueBoolean,
+		lhs:        boolFactorExpression(lhs),
+		rhs:        boolFactorExpression(rhs),
 	}
 	return boolFactor, nil
 }
 
+// boolFactorExpression makes an expression of just the factor
+func boolFactorExpression(boolFactor *BoolFactor) *Expression {
+	boolTerm := &BoolTerm{boolAndFactors: []*BoolFactor{boolFactor}}
+	return &Expression{exprnType: ExprnBoolean, boolExpression: &BoolExpression{boolOrTerms: []*BoolTerm{boolTerm}}}
+}
+
+var comparators = map[itemType]IntComparatorType{
+	itemLessThan:      IntCompLessThan,
+	itemLessEquals:    IntCompLessEquals,
+	itemGreaterThan:   IntCompGreaterThan,
+	itemGreaterEquals: IntCompGreaterEquals,
+	itemEquals:        IntCompEquals,
+	itemNotEquals:     IntCompNotEquals,
+}
+
+// peekValueType works out the type of the expression starting at the next token
+// returning ValueNone if it can't tell
+func (parser *Parser) peekValueType() ValueType {
+	item := parser.peek()
+	switch item.typ {
+	case itemIdentifier:
+		if valueType := parser.lookupType(item.val); valueType != ValueNone {
+			return valueType
+		}
+		if proc, ok := parser.procs[item.val]; ok {
+			return proc.returnType
+		}
+	case itemStringLiteral, itemStrInt, itemStrBool, itemStrCounter, itemStrOid, itemStrTimeticks,
+		itemStrIpaddress, itemStrBitset, itemStrBytes, itemStrGuage:
+		return ValueString
+	case itemOidLiteral:
+		return ValueOid
+	case itemLeftSquareBracket:
+		return ValueBitset
+	case itemTrue, itemFalse:
+		return ValueBoolean
+	case itemIntegerLiteral, itemAlias, itemMinus, itemPolls, itemQueued, itemBitNot:
+		return ValueInteger
+	default:
+		if builtin, ok := builtins[item.typ]; ok {
+			return builtin.returnType
+		}
+	}
+	return ValueNone
+}
+
+func isIntType(valueType ValueType) bool {
+	return valueType == ValueInteger || valueType == ValueCounter || valueType == ValueTimeticks || valueType == ValueGuage
+}
+
+// peekCompatible checks the expression at the next token can be of the type
+// returning the type it has or ValueNone if that isn't known yet
+func (parser *Parser) peekCompatible(valueType ValueType) (rhsType ValueType, ok bool) {
+	rhsType = parser.peekValueType()
+	switch {
+	case rhsType == ValueNone || rhsType == valueType:
+		return rhsType, true
+	case isIntType(valueType) && isIntType(rhsType):
+		return rhsType, true
+	case valueType == ValueIpv4address && parser.peek().typ == itemOidLiteral:
+		// an address literal looks like an OID
+		return rhsType, true
+	}
+	return rhsType, false
+}
+
+// checkComparable checks the expression at the next token can be compared with the type
+func (parser *Parser) checkComparable(valueType ValueType) error {
+	if rhsType, ok := parser.peekCompatible(valueType); !ok {
+		return parser.errorf("Can not compare %v with %v", Type{valueType: valueType}, Type{valueType: rhsType})
+	}
+	return nil
+}
+
+// parseComparison parses a comparison of expressions of the type
+func (parser *Parser) parseComparison(valueType ValueType) (comparison *Comparison, err error) {
+	comparison = new(Comparison)
+	comparison.valueType = valueType
+
+	comparison.lhs, err = parser.parseTypedExpression(valueType)
+	if err != nil {
+		return nil, err
+	}
+	comparison.comparator, comparison.rhs, err = parser.parseComparisonRhs(valueType)
+	if err != nil {
+		return nil, err
+	}
+	return comparison, nil
+}
+
+// parseComparisonRhs parses the comparator and the expression compared with
+func (parser *Parser) parseComparisonRhs(valueType ValueType) (comparator IntComparatorType, rhs *Expression, err error) {
+	item := parser.nextItem()
+	comparator, ok := comparators[item.typ]
+	if !ok {
+		return 0, nil, parser.errorf("Bad operator for %s", strings.ToLower(Type{valueType: valueType}.String()))
+	}
+	err = parser.checkComparable(valueType)
+	if err != nil {
+		return 0, nil, err
+	}
+	rhs, err = parser.parseTypedExpression(valueType)
+	if err != nil {
+		return 0, nil, err
+	}
+	return comparator, rhs, nil
+}
+
 func (parser *Parser) parseIntComparison() (intComp *IntComparison, err error) {
 	intComp = new(IntComparison)
 
@@ -1827,21 +3416,16 @@ func (parser *Parser) parseIntComparison() (intComp *IntComparison, err error) {
 	}
 
 	item := parser.nextItem()
-	switch item.typ {
-	case itemLessThan:
-		intComp.intComparator = IntCompLessThan
-	case itemLessEquals:
-		intComp.intComparator = IntCompLessEquals
-	case itemGreaterThan:
-		intComp.intComparator = IntCompGreaterThan
-	case itemGreaterEquals:
-		intComp.intComparator = IntCompGreaterEquals
-	case itemEquals:
-		intComp.intComparator = IntCompEquals
-	default:
+	comparator, ok := comparators[item.typ]
+	if !ok {
 		return nil, parser.errorf("Bad operator for integer")
 	}
+	intComp.intComparator = comparator
 
+	err = parser.checkComparable(ValueInteger)
+	if err != nil {
+		return nil, err
+	}
 	intComp.rhsIntExpression, err = parser.parseIntExpression()
 	if err != nil {
 		return nil, err
@@ -1856,6 +3440,14 @@ func (parser *Parser) parseIntFactor() (intFactor *IntFactor, err error) {
 	item := parser.nextItem()
 	switch item.typ {
 	case itemIdentifier:
+		if fn := parser.lookupFunc(item.val, ValueInteger, ValueCounter, ValueTimeticks, ValueGuage); fn != nil {
+			intFactor.intFactorType = IntFactorCall
+			intFactor.call, err = parser.parseCallArgs(fn)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
 		valType := parser.lookupType(item.val)
 		if valType != ValueInteger && valType != ValueCounter && valType != ValueTimeticks && valType != ValueGuage {
 			return nil, parser.errorf("Not numeric variable in integer expression")
@@ -1875,12 +3467,64 @@ func (parser *Parser) parseIntFactor() (intFactor *IntFactor, err error) {
 			return nil, parser.errorf("Invalid integer alias")
 		}
 		intFactor.intConst = x
+	case itemPolls:
+		// polls(<identifier>)
+		intFactor.intFactorType = IntFactorPolls
+		err = parser.match(itemLeftParen, "polls")
+		if err != nil {
+			return nil, err
+		}
+		idItem, err := parser.matchItem(itemIdentifier, "polls")
+		if err != nil {
+			return nil, err
+		}
+		typ, ok := parser.variables.types[idItem.val]
+		if !ok || typ.oid == "" {
+			return nil, parser.errorf("Polls of non OID variable: %s", idItem.val)
+		}
+		intFactor.intIdentifier = idItem.val
+		err = parser.match(itemRightParen, "polls")
+		if err != nil {
+			return nil, err
+		}
+	case itemQueued:
+		// queued(<identifier>)
+		intFactor.intFactorType = IntFactorQueued
+		err = parser.match(itemLeftParen, "queued")
+		if err != nil {
+			return nil, err
+		}
+		idItem, err := parser.matchItem(itemIdentifier, "queued")
+		if err != nil {
+			return nil, err
+		}
+		typ, ok := parser.variables.types[idItem.val]
+		if !ok || typ.snmpMode != SnmpModeReadWriteBlocked {
+			return nil, parser.errorf("Queued of non rwb variable: %s", idItem.val)
+		}
+		intFactor.intIdentifier = idItem.val
+		err = parser.match(itemRightParen, "queued")
+		if err != nil {
+			return nil, err
+		}
 	case itemMinus:
 		intFactor.intFactorType = IntFactorMinus
 		intFactor.minusIntFactor, err = parser.parseIntFactor()
 		if err != nil {
 			return nil, parser.errorf("Minus missing int factor")
 		}
+	case itemBitNot:
+		intFactor.intFactorType = IntFactorBitNot
+		intFactor.bitNotFactor, err = parser.parseIntFactor()
+		if err != nil {
+			return nil, err
+		}
+	case itemIf:
+		intFactor.intFactorType = IntFactorConditional
+		intFactor.conditional, err = parser.parseConditional(ValueInteger)
+		if err != nil {
+			return nil, err
+		}
 	case itemLeftParen:
 		intFactor.intFactorType = IntFactorBracket
 		intFactor.bracketedExprn, err = parser.parseIntExpression()
@@ -1893,6 +3537,18 @@ func (parser *Parser) parseIntFactor() (intFactor *IntFactor, err error) {
 			return nil, err
 		}
 	default:
+		if builtin, ok := builtins[item.typ]; ok && builtin.returnType == ValueInteger {
+			intFactor.intFactorType = IntFactorBuiltin
+			intFactor.builtin, err = parser.parseBuiltinCall(builtin)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if item.typ == itemError {
+			// a number the lexer could not scan
+			return nil, parser.errorf("%s", item.val)
+		}
 		return nil, parser.errorf("Invalid item/operator in integer factor")
 	}
 	return intFactor, nil
@@ -1913,6 +3569,21 @@ const (
 	SnmpModeReadWriteBlocked
 )
 
+// QueuePolicy is what a rwb variable does with a set when its queue is full
+type QueuePolicy int
+
+const (
+	QueueBlock      QueuePolicy = iota // the set waits for a read
+	QueueDropOldest                    // the oldest value is dropped
+	QueueReject                        // the set fails with resourceUnavailable
+)
+
+var queuePolicies = map[string]QueuePolicy{
+	"block":       QueueBlock,
+	"drop-oldest": QueueDropOldest,
+	"reject":      QueueReject,
+}
+
 type FieldInfo struct {
 	totalSize    uint
 	fieldSizes   map[string]uint // field-id -> size
@@ -1924,7 +3595,9 @@ type Type struct {
 	oid           string
 	initMode      InitMode
 	snmpMode      SnmpMode
-	externalValue chan *Value
+	externalValue chan *Value // values set by managers of a rw variable for read statements
+	queueSize     int         // of a rwb variable
+	queuePolicy   QueuePolicy // of a rwb variable
 	lineNum       int
 	id            string
 	fieldInfo     FieldInfo
@@ -1950,6 +3623,10 @@ func (typ Type) String() string {
 		str = "Bitset"
 	case ValueOid:
 		str = "Oid"
+	case ValueIpv4address:
+		str = "Ipaddress"
+	case ValueBytes:
+		str = "Bytes"
 	case ValueNone:
 		str = "None"
 	}
@@ -1977,6 +3654,29 @@ func (typ Type) String() string {
 	return str
 }
 
+func (task *Task) String() string {
+	switch task.taskType {
+	case TaskEvery:
+		return fmt.Sprintf("Every block at line %d", task.lineNum)
+	case TaskAfter:
+		return fmt.Sprintf("After block at line %d", task.lineNum)
+	case TaskAt:
+		return fmt.Sprintf("At block at line %d", task.lineNum)
+	}
+	return "Task " + task.name
+}
+
+func (handlerType HandlerType) String() string {
+	if handlerType == HandlerGet {
+		return "get"
+	}
+	return "set"
+}
+
+func (handler *Handler) String() string {
+	return fmt.Sprintf("On %v %s at line %d", handler.handlerType, handler.identifier, handler.lineNum)
+}
+
 func (loopTyp LoopType) String() string {
 	switch loopTyp {
 	case LoopForever:
@@ -1989,6 +3689,22 @@ func (loopTyp LoopType) String() string {
 	return "unknown loop"
 }
 
+func (intOp IntOperationType) String() string {
+	switch intOp {
+	case IntOpBitOr:
+		return "Bitwise or bor"
+	case IntOpBitXor:
+		return "Bitwise xor bxor"
+	case IntOpBitAnd:
+		return "Bitwise and band"
+	case IntOpShiftLeft:
+		return "Shift left <<"
+	case IntOpShiftRight:
+		return "Shift right >>"
+	}
+	return "unknown operator"
+}
+
 func (intComp IntComparatorType) String() string {
 	switch intComp {
 	case IntCompEquals:
@@ -2001,6 +3717,8 @@ func (intComp IntComparatorType) String() string {
 		return "Less or Equals <="
 	case IntCompLessThan:
 		return "Less than <"
+	case IntCompNotEquals:
+		return "Not Equals #"
 	}
 	return "unknown operator"
 }
@@ -2011,9 +3729,15 @@ type Statement struct {
 	assignmentStmt *AssignmentStatement
 	ifStmt         *IfStatement
 	loopStmt       *LoopStatement
+	forStmt        *ForStatement
 	printStmt      *PrintStatement
 	sleepStmt      *SleepStatement
 	readStmt       *ReadStatement
+	dumpStmt       *DumpStatement
+	callStmt       *CallStatement
+	returnStmt     *ReturnStatement
+	waitStmt       *WaitStatement
+	selectStmt     *SelectStatement
 }
 
 type LoopStatement struct {
@@ -2048,6 +3772,131 @@ type PrintStatement struct {
 
 type ReadStatement struct {
 	identifier string
+	timeout    *Timeout
+}
+
+// ForStatement runs its statements for each bit of a bitset
+type ForStatement struct {
+	identifier       string
+	bitsetExpression *BitsetExpression
+	stmtList         []*Statement
+}
+
+// SelectStatement reads whichever of its variables a manager sets first
+type SelectStatement struct {
+	cases           []*SelectCase
+	timeout         *Timeout
+	timeoutStmtList []*Statement
+}
+
+type SelectCase struct {
+	identifier string
+	stmtList   []*Statement
+}
+
+type DumpStatement struct {
+	filename *StringExpression
+}
+
+type CallStatement struct {
+	call *Call
+}
+
+type WaitType int
+
+const (
+	WaitTask WaitType = iota
+	WaitPoll
+	WaitUntil
+)
+
+type WaitStatement struct {
+	waitType   WaitType
+	taskName   string
+	identifier string         // variable polled
+	times      *IntExpression // polls to wait for, nil for once
+	condition  *BoolExpression
+	timeout    *Timeout
+}
+
+// Timeout of a blocking statement
+type Timeout struct {
+	exprn      *IntExpression
+	units      TimeUnit
+	timedOutId string // boolean variable set to whether it timed out, if any
+}
+
+type TaskType int
+
+const (
+	TaskNamed TaskType = iota
+	TaskEvery
+	TaskAfter
+	TaskAt
+)
+
+// TaskWait is a wait for a task found while parsing
+type TaskWait struct {
+	taskName string
+	lineNum  int
+	waiter   *Task // nil outside of a task
+}
+
+type Task struct {
+	taskType TaskType
+	name     string // of a named task
+
+	// when an every or after block runs
+	interval *IntExpression
+	units    TimeUnit
+
+	// when an at block runs
+	atHour   int
+	atMinute int
+	atSecond int
+
+	stmtList []*Statement
+	lineNum  int
+}
+
+// locals of a handler
+const (
+	handlerOldId       = "old"
+	handlerNewId       = "new"
+	handlerRequesterId = "requester"
+)
+
+type HandlerType int
+
+const (
+	HandlerSet HandlerType = iota
+	HandlerGet
+)
+
+type Handler struct {
+	handlerType HandlerType
+	identifier  string  // of the variable
+	locals      []*Type // e.g. old, new and requester
+	stmtList    []*Statement
+	lineNum     int
+}
+
+type ReturnStatement struct {
+	exprn *Expression // nil in a procedure
+}
+
+type Procedure struct {
+	name       string
+	params     []*Type   // in order with id and valueType
+	returnType ValueType // ValueNone unless a function
+	stmtList   []*Statement
+}
+
+type Call struct {
+	name    string
+	proc    *Procedure
+	args    []*Expression
+	lineNum int
 }
 
 type SleepStatement struct {
@@ -2065,6 +3914,7 @@ type Expression struct {
 	oidExpression    *OidExpression
 	addrExpression   *AddrExpression
 	bytesExpression  *BytesExpression
+	conditional      *Conditional // of any type where any expression can go
 }
 
 type BytesExpression struct {
@@ -2074,6 +3924,7 @@ type BytesExpression struct {
 //<bool-expression>::=<bool-term>{<or><bool-term>}
 //<bool-term>::=<bool-factor>{<and><bool-factor>}
 //<bool-factor>::=<bool-constant>|<bool-identifier>|<not><bool-factor>|(<bool-expression>)|<int-comparison>
+//               |<function-call>
 //<int-comparison>::=<int-expression><int-comp><int-expression>
 
 type BoolExpression struct {
@@ -2093,6 +3944,11 @@ const (
 	BoolFactorBracket
 	BoolFactorIntComparison
 	BoolFactorContains
+	BoolFactorCall
+	BoolFactorComparison
+	BoolFactorStrContains
+	BoolFactorBuiltin
+	BoolFactorConditional
 )
 
 type BoolFactor struct {
@@ -2105,6 +3961,22 @@ type BoolFactor struct {
 	intComparison  *IntComparison
 	bitsetId       string
 	bitsetElement  *IntExpression
+	call           *Call
+	comparison     *Comparison
+	strContainer   *StringExpression
+	strElement     *StringExpression
+	builtin        *BuiltinCall
+	conditional    *Conditional
+}
+
+// Comparison of values other than integers
+// Bitsets are ordered by subset and booleans can only be equal or not.
+type Comparison struct {
+	comparator IntComparatorType
+	valueType  ValueType
+
+	lhs *Expression
+	rhs *Expression
 }
 
 type IntComparison struct {
@@ -2118,15 +3990,38 @@ type IntComparison struct {
 //<int-expression>::=<int-term>{<plus-or-minus><int-term>}
 //<int-term>::=<int-factor>{<times-or-divide><int-factor>}
 //<int-factor>::=<int-constant>|<int-identifier>|<minus><int-factor>|(<int-expression>)
+//              |<function-call>|polls(<identifier>)
+//<function-call>::=<identifier>([<expression>{,<expression>}])
+
+type IntOperationType int
+
+const (
+	IntOpNone IntOperationType = iota
+	IntOpBitOr
+	IntOpBitXor
+	IntOpBitAnd
+	IntOpShiftLeft
+	IntOpShiftRight
+)
 
+// An integer expression is either a sum of terms or an operation on two expressions
 type IntExpression struct {
 	plusTerms  []*IntTerm
 	minusTerms []*IntTerm
+
+	intOp   IntOperationType
+	lhs     *IntExpression
+	rhs     *IntExpression
+	lineNum int
 }
 
+// The product of the times factors is divided by the divide factors
+// and then taken modulo the mod factors
 type IntTerm struct {
 	timesFactors  []*IntFactor
 	divideFactors []*IntFactor
+	modFactors    []*IntFactor
+	lineNum       int
 }
 
 type IntFactorType int
@@ -2136,6 +4031,12 @@ const (
 	IntFactorId
 	IntFactorMinus
 	IntFactorBracket
+	IntFactorCall
+	IntFactorPolls
+	IntFactorQueued
+	IntFactorBuiltin
+	IntFactorBitNot
+	IntFactorConditional
 )
 
 type IntFactor struct {
@@ -2144,7 +4045,11 @@ type IntFactor struct {
 	intConst       int
 	intIdentifier  string
 	minusIntFactor *IntFactor
+	bitNotFactor   *IntFactor
 	bracketedExprn *IntExpression
+	call           *Call
+	builtin        *BuiltinCall
+	conditional    *Conditional
 }
 
 // <string-expression> ::= <str-term> {<binary-str-operator> <str-term>}
@@ -2168,12 +4073,18 @@ type AddrExprnType int
 const (
 	AddrExprnValue AddrExprnType = iota
 	AddrExprnId
+	AddrExprnCall
+	AddrExprnBuiltin
+	AddrExprnConditional
 )
 
 type AddrExpression struct {
 	addrExprnType AddrExprnType
 	addrVal       string
 	identifier    string
+	call          *Call
+	builtin       *BuiltinCall
+	conditional   *Conditional
 }
 
 type OidTermType int
@@ -2182,6 +4093,9 @@ const (
 	OidTermValue OidTermType = iota
 	OidTermId
 	OidTermBracket
+	OidTermCall
+	OidTermBuiltin
+	OidTermConditional
 )
 
 type OidTerm struct {
@@ -2190,6 +4104,9 @@ type OidTerm struct {
 	oidVal         string
 	identifier     string
 	bracketedExprn *OidExpression
+	call           *Call
+	builtin        *BuiltinCall
+	conditional    *Conditional
 }
 
 type StringTermType int
@@ -2204,6 +4121,10 @@ const (
 	StringTermStringedAddrExprn
 	StringTermStringedBitsetExprn
 	StringTermStringedBytesExprn
+	StringTermCall
+	StringTermBuiltin
+	StringTermStringedId
+	StringTermConditional
 )
 
 type StringTerm struct {
@@ -2218,6 +4139,9 @@ type StringTerm struct {
 	stringedAddrExprn   *AddrExpression
 	stringedBitsetExprn *BitsetExpression
 	stringedBytesExprn  *BytesExpression
+	call                *Call
+	builtin             *BuiltinCall
+	conditional         *Conditional
 }
 
 type BitsetTermType int
@@ -2226,12 +4150,27 @@ const (
 	BitsetTermValue BitsetTermType = iota
 	BitsetTermId
 	BitsetTermBracket
+	BitsetTermCall
+	BitsetTermBuiltin
+	BitsetTermConditional
+	BitsetTermIntersection
 )
 
 type BitsetMap map[uint]bool
 
 type BytesMap map[string]uint
 
+// bits gets the positions of the bits set in order
+func (bitsetValue BitsetMap) bits() (bits []int) {
+	for k, set := range bitsetValue {
+		if set {
+			bits = append(bits, int(k))
+		}
+	}
+	sort.Ints(bits)
+	return bits
+}
+
 func (bitsetValue BitsetMap) String() (str string) {
 	var keys []int // use int instead of uint so we can use sort.Ints()
 	for k := range bitsetValue {
@@ -2276,6 +4215,10 @@ type BitsetTerm struct {
 	bitsetVal      *BitsetValue
 	identifier     string
 	bracketedExprn *BitsetExpression
+	call           *Call
+	builtin        *BuiltinCall
+	conditional    *Conditional
+	intersectTerms []*BitsetTerm
 }
 
 type BitsetValue struct {
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Capture of the SNMP datagrams we receive and send in pcap format
+// The datagrams are wrapped in synthesised IP and UDP headers (LINKTYPE_RAW)
+// so the file can be opened in Wireshark without capturing as root.
+
+const (
+	pcapMagic      = 0xa1b2c3d4 // microsecond timestamps
+	pcapSnapLen    = 65535
+	pcapLinkRaw    = 101 // raw IPv4/IPv6
+	ipProtocolUdp  = 17
+	ipv4HeaderLen  = 20
+	ipv6HeaderLen  = 40
+	udpHeaderLen   = 8
+	ipDefaultTtl   = 64
+	ipv4DontFrag   = 0x4000
+	ipv6VersionBit = 0x60
+)
+
+type PcapWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+	ipId uint16 // identification of IPv4 packets
+}
+
+func openPcapFile(filename string) (*PcapWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	pcap, err := newPcapWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return pcap, nil
+}
+
+// newPcapWriter writes the pcap file header
+func newPcapWriter(w io.Writer) (*PcapWriter, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2) // version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkRaw)
+	_, err := w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// write records a datagram sent from src to dst at the time
+func (pcap *PcapWriter) write(t time.Time, src net.Addr, dst net.Addr, datagram []byte) error {
+	srcAddr, _ := src.(*net.UDPAddr)
+	dstAddr, _ := dst.(*net.UDPAddr)
+	if srcAddr == nil || dstAddr == nil {
+		return nil // not UDP so nothing to synthesise
+	}
+
+	pcap.lock.Lock()
+	defer pcap.lock.Unlock()
+
+	var packet []byte
+	if srcAddr.IP.To4() != nil && dstAddr.IP.To4() != nil {
+		pcap.ipId++
+		packet = ipv4Packet(pcap.ipId, srcAddr, dstAddr, datagram)
+	} else {
+		packet = ipv6Packet(srcAddr, dstAddr, datagram)
+	}
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+	_, err := pcap.w.Write(append(record, packet...))
+	return err
+}
+
+func udpHeader(srcPort int, dstPort int, datagram []byte) []byte {
+	header := make([]byte, udpHeaderLen)
+	binary.BigEndian.PutUint16(header[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(header[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(header[4:], uint16(udpHeaderLen+len(datagram)))
+	return header
+}
+
+func ipv4Packet(id uint16, src *net.UDPAddr, dst *net.UDPAddr, datagram []byte) []byte {
+	header := make([]byte, ipv4HeaderLen)
+	header[0] = 0x45 // version 4, 5 words of header
+	binary.BigEndian.PutUint16(header[2:], uint16(ipv4HeaderLen+udpHeaderLen+len(datagram)))
+	binary.BigEndian.PutUint16(header[4:], id)
+	binary.BigEndian.PutUint16(header[6:], ipv4DontFrag)
+	header[8] = ipDefaultTtl
+	header[9] = ipProtocolUdp
+	copy(header[12:], src.IP.To4())
+	copy(header[16:], dst.IP.To4())
+	binary.BigEndian.PutUint16(header[10:], ipChecksum(header))
+
+	// UDP checksum is optional for IPv4 so leave it zero
+	packet := append(header, udpHeader(src.Port, dst.Port, datagram)...)
+	return append(packet, datagram...)
+}
+
+func ipv6Packet(src *net.UDPAddr, dst *net.UDPAddr, datagram []byte) []byte {
+	udp := append(udpHeader(src.Port, dst.Port, datagram), datagram...)
+
+	header := make([]byte, ipv6HeaderLen)
+	header[0] = ipv6VersionBit
+	binary.BigEndian.PutUint16(header[4:], uint16(len(udp)))
+	header[6] = ipProtocolUdp
+	header[7] = ipDefaultTtl
+	copy(header[8:], src.IP.To16())
+	copy(header[24:], dst.IP.To16())
+
+	// UDP checksum is mandatory for IPv6 and covers a pseudo header
+	pseudo := make([]byte, 0, 40+len(udp))
+	pseudo = append(pseudo, header[8:40]...)
+	pseudo = append(pseudo, 0, 0)
+	pseudo = append(pseudo, byte(len(udp)>>8), byte(len(udp)))
+	pseudo = append(pseudo, 0, 0, 0, ipProtocolUdp)
+	pseudo = append(pseudo, udp...)
+	checksum := ipChecksum(pseudo)
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], checksum)
+
+	return append(header, udp...)
+}
+
+// ipChecksum is the ones' complement of the ones' complement sum of 16 bit words
+func ipChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+func ExamplePcap1() {
+	var buf bytes.Buffer
+	pcap, err := newPcapWriter(&buf)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("% x\n", buf.Bytes())
+
+	t := time.Unix(1714557600, 123456000)
+	agent := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 161}
+	manager := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 40000}
+	for _, dst := range []*net.UDPAddr{manager, {IP: net.ParseIP("::1"), Port: 40000}} {
+		buf.Reset()
+		err = pcap.write(t, agent, dst, []byte{0x30, 0x00})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		record := buf.Bytes()
+		fmt.Printf("% x\n", record[:16])
+		packet := record[16:]
+		if dst == manager {
+			fmt.Printf("% x\n", packet[:20])
+			fmt.Printf("header checksum ok: %v\n", ipChecksum(packet[:20]) == 0)
+			fmt.Printf("% x\n", packet[20:])
+			continue
+		}
+		fmt.Printf("% x\n", packet[:8])
+		fmt.Printf("% x\n", packet[8:40])
+		fmt.Printf("% x\n", packet[40:])
+	}
+	// Output:
+	// d4 c3 b2 a1 02 00 04 00 00 00 00 00 00 00 00 00 ff ff 00 00 65 00 00 00
+	// a0 12 32 66 40 e2 01 00 1e 00 00 00 1e 00 00 00
+	// 45 00 00 1e 00 01 40 00 40 11 26 cc 0a 00 00 01 0a 00 00 02
+	// header checksum ok: true
+	// 00 a1 9c 40 00 0a 00 00 30 00
+	// a0 12 32 66 40 e2 01 00 32 00 00 00 32 00 00 00
+	// 60 00 00 00 00 0a 11 40
+	// 00 00 00 00 00 00 00 00 00 00 ff ff 0a 00 00 01 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 01
+	// 00 a1 9c 40 00 0a 28 f7 30 00
+}
+
+func ExamplePcap2() {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close()
+	enablePktinfo(conn)
+	server := &SnmpServer{conn: conn}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	// an IPv4 datagram to the wildcard socket is captured as IPv4
+	client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+
+	buffer := make([]byte, 16)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, source, dest, err := server.readDatagram(buffer)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(buffer[:n]), source.IP.To4() != nil, dest.IP.To4() != nil, dest.Port == port)
+	// Output:
+	// ping true true true
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "net"
+
+// enablePktinfo does nothing as packet info is only read on linux
+func enablePktinfo(conn *net.UDPConn) error {
+	return nil
+}
+
+// pktinfoDest never finds a destination address without packet info
+func pktinfoDest(oob []byte) net.IP {
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// enablePktinfo asks for the destination address of each datagram read
+// IPv4 datagrams arriving on a dual stack socket come as IPv4 mapped IPv6 addresses
+func enablePktinfo(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		err6 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1)
+		err4 := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+		if err6 != nil && err4 != nil {
+			sockErr = err4
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// pktinfoDest finds the destination address in the control messages of a datagram
+// returning nil if there is none
+func pktinfoDest(oob []byte) net.IP {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, msg := range msgs {
+		switch {
+		case msg.Header.Level == syscall.IPPROTO_IP && msg.Header.Type == syscall.IP_PKTINFO &&
+			len(msg.Data) >= syscall.SizeofInet4Pktinfo:
+			// ifindex, local address, header destination address
+			return net.IPv4(msg.Data[8], msg.Data[9], msg.Data[10], msg.Data[11])
+		case msg.Header.Level == syscall.IPPROTO_IPV6 && msg.Header.Type == syscall.IPV6_PKTINFO &&
+			len(msg.Data) >= syscall.SizeofInet6Pktinfo:
+			return append(net.IP(nil), msg.Data[:net.IPv6len]...)
+		}
+	}
+	return nil
+}
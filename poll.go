@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Counts of managers reading the OIDs of the program's variables
+// Statements waiting for polls are woken by closing the changed channel, which is then replaced.
+
+type Polls struct {
+	lock    sync.Mutex
+	counts  map[string]int // oid --> times read
+	changed chan struct{}
+}
+
+func newPolls(variables *Variables) *Polls {
+	polls := &Polls{counts: make(map[string]int), changed: make(chan struct{})}
+	for oidStr := range variables.typesFromOid {
+		polls.counts[oidStr] = 0
+	}
+	return polls
+}
+
+// incr counts a read of the OID if it is a variable's
+func (polls *Polls) incr(oidStr string) {
+	polls.lock.Lock()
+	defer polls.lock.Unlock()
+
+	if _, ok := polls.counts[oidStr]; !ok {
+		return
+	}
+	polls.counts[oidStr]++
+	close(polls.changed)
+	polls.changed = make(chan struct{})
+}
+
+func (polls *Polls) count(oidStr string) int {
+	polls.lock.Lock()
+	defer polls.lock.Unlock()
+
+	return polls.counts[oidStr]
+}
+
+// wait until the OID has been read the number of times from now
+// returning whether it timed out first
+func (polls *Polls) wait(oidStr string, times int, stop <-chan struct{}, expired <-chan time.Time) (timedOut bool, err error) {
+	polls.lock.Lock()
+	target := polls.counts[oidStr] + times
+	for polls.counts[oidStr] < target {
+		changed := polls.changed
+		polls.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-expired:
+			return true, nil
+		case <-stop:
+			return false, errStopped
+		}
+		polls.lock.Lock()
+	}
+	polls.lock.Unlock()
+	return false, nil
+}
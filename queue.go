@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// Sets of a rwb variable are queued for the program's read statements so the manager
+// gets its response straight away. When the queue is full the variable's policy decides
+// whether the set waits for the program, drops the oldest value or is rejected.
+
+// default length of the queue of a rwb variable
+const defaultQueueSize = 10
+
+var errQueueFull = errors.New("Queue full")
+
+type SetQueue struct {
+	lock   sync.Mutex
+	values []*Value
+	size   int
+	policy QueuePolicy
+	ready  chan struct{} // signalled when values are added
+	space  chan struct{} // signalled when values are taken
+}
+
+func newSetQueue(typ *Type) *SetQueue {
+	return &SetQueue{
+		size:   typ.queueSize,
+		policy: typ.queuePolicy,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// wake signals the channel unless it is already signalled
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// push queues a set value applying the policy when the queue is full
+// A blocked set is dropped if the program stops.
+func (queue *SetQueue) push(val *Value, stop <-chan struct{}) error {
+	queue.lock.Lock()
+	for len(queue.values) >= queue.size {
+		switch queue.policy {
+		case QueueReject:
+			queue.lock.Unlock()
+			return errQueueFull
+		case QueueDropOldest:
+			queue.values = queue.values[1:]
+		case QueueBlock:
+			queue.lock.Unlock()
+			select {
+			case <-queue.space:
+			case <-stop:
+				return nil
+			}
+			queue.lock.Lock()
+		}
+	}
+	queue.values = append(queue.values, val)
+	hasSpace := len(queue.values) < queue.size
+	queue.lock.Unlock()
+
+	wake(queue.ready)
+	if hasSpace {
+		// pass on the signal to any other blocked set as several values may have been taken
+		wake(queue.space)
+	}
+	return nil
+}
+
+// pop takes the oldest value if there is one
+func (queue *SetQueue) pop() (val *Value, ok bool) {
+	queue.lock.Lock()
+	if len(queue.values) == 0 {
+		queue.lock.Unlock()
+		return nil, false
+	}
+	val = queue.values[0]
+	queue.values = queue.values[1:]
+	remaining := len(queue.values)
+	queue.lock.Unlock()
+
+	wake(queue.space)
+	if remaining > 0 {
+		wake(queue.ready)
+	}
+	return val, true
+}
+
+func (queue *SetQueue) len() int {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	return len(queue.values)
+}
+
+// accepts reports whether n more values would be queued rather than rejected
+func (queue *SetQueue) accepts(n int) bool {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	return queue.policy != QueueReject || len(queue.values)+n <= queue.size
+}
+
+// initSetQueues makes the queues of the rwb variables
+func (interp *Interpreter) initSetQueues() {
+	interp.setQueues = make(map[string]*SetQueue)
+	for id, typ := range interp.variables.types {
+		if typ.snmpMode == SnmpModeReadWriteBlocked {
+			interp.setQueues[id] = newSetQueue(typ)
+		}
+	}
+}
+
+// rejectedSet finds the first varbind of a set request which a full queue would reject
+// returning its index or -1 if all would be queued
+func (interp *Interpreter) rejectedSet(varbinds []SnmpVarbind) int {
+	counts := make(map[*SetQueue]int)
+	for i, vb := range varbinds {
+		typ, ok := interp.variables.typesFromOid[vb.oid]
+		if !ok {
+			continue
+		}
+		queue, ok := interp.setQueues[typ.id]
+		if !ok {
+			continue
+		}
+		counts[queue]++
+		if !queue.accepts(counts[queue]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// queuedCount gets how many sets of the rwb variable are waiting to be read
+func (interp *Interpreter) queuedCount(id string) int {
+	return interp.setQueues[id].len()
+}
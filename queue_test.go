@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+func ExampleSetQueue1() {
+	interp, done := startTestProgram(`
+var
+  jobs: 4.1.1.7.0 rwb 2 reject string
+  latest: 4.1.1.8.0 rwb 2 drop-oldest integer
+endvar
+run
+  sleep 100 msecs
+  print "queued " + strInt(queued(jobs)) + " jobs and " + strInt(queued(latest)) + " latest"
+  loop queued(latest) > 0
+    read latest
+    print "latest " + strInt(latest)
+  endloop
+  read jobs
+  print "job " + jobs
+endrun`)
+
+	source := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 1161}
+	for _, job := range []string{"a", "b", "c"} {
+		setTestValue(interp, source, ".1.3.6.1.4.1.1.7.0", job)
+	}
+	for i := 1; i <= 3; i++ {
+		setTestValue(interp, source, ".1.3.6.1.4.1.1.8.0", i)
+	}
+
+	err := <-done
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// Queue full
+	// queued 2 jobs and 2 latest
+	// latest 2
+	// latest 3
+	// job a
+}
+
+func ExampleSetQueue2() {
+	interp, done := startTestProgram(`
+var
+  jobs: 4.1.1.7.0 rwb 3 reject string
+endvar
+run
+  sleep 100 msecs
+endrun`)
+	server := &SnmpServer{interp: interp, stats: new(SnmpStats), communities: Communities{{name: "private", writable: true}}}
+	source := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1161}
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.7.0", "a")
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.7.0", "b")
+
+	for _, version := range []int{snmpVersion1, snmpVersion2c} {
+		request := &SnmpPacket{version: version, community: "private", pduType: pduSetRequest, requestId: version}
+		request.varbinds = []SnmpVarbind{
+			{".1.3.6.1.4.1.1.7.0", berOctetString, []byte("c")},
+			{".1.3.6.1.4.1.1.7.0", berOctetString, []byte("d")},
+		}
+		datagram, err := encodeSnmpPacket(request)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		datagram, err = server.processDatagram(datagram, source)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		response, err := decodeSnmpPacket(datagram)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%d: %s error: %d/%d\n", response.requestId, pduNames[response.pduType], response.errorStatus, response.errorIndex)
+	}
+	fmt.Println("queued", interp.queuedCount("jobs"))
+
+	err := <-done
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// 0: response error: 5/2
+	// 1: response error: 13/2
+	// queued 2
+}
+
+func ExampleSetQueue3() {
+	for _, prog := range []string{`
+var
+  jobs: 4.1.1.7.0 rwb 0 string
+endvar
+run
+endrun`, `
+var
+  jobs: 4.1.1.7.0 rwb 5 drop-newest string
+endvar
+run
+endrun`, `
+var
+  jobs: 4.1.1.7.0 rw string
+  n: integer
+endvar
+run
+  n = queued(jobs)
+endrun`} {
+		_, err := NewParser(lex("test", prog)).ParseProgram()
+		fmt.Println(err)
+	}
+	// Output:
+	// test: Error at line 3: Queue size of rwb variable is not positive
+	// test: Error at line 3: Unknown queue policy of rwb variable: drop-newest
+	// test: Error at line 7: Queued of non rwb variable: jobs
+}
+
+// a set blocked on a full queue is answered once the program reads
+func ExampleSetQueue4() {
+	interp, done := startTestProgram(`
+var
+  jobs: 4.1.1.7.0 rwb 1 string
+endvar
+run
+  sleep 100 msecs
+  read jobs
+  print "job " + jobs
+  read jobs
+  print "job " + jobs
+endrun`)
+
+	source := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 1161}
+	start := time.Now()
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.7.0", "a")
+	setTestValue(interp, source, ".1.3.6.1.4.1.1.7.0", "b")
+	fmt.Println("second set waited", time.Since(start) >= 100*time.Millisecond)
+
+	err := <-done
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// job a
+	// second set waited true
+	// job b
+}
+
+func ExampleSetQueue5() {
+	queue := newSetQueue(&Type{queueSize: 2, queuePolicy: QueueBlock})
+	queue.push(intValue(1), nil)
+	queue.push(intValue(2), nil)
+
+	// two blocked sets both go ahead when two values are taken
+	// before either set wakes up so the two signals merge into one
+	pushed := make(chan bool)
+	for _, x := range []int{3, 4} {
+		go func(x int) {
+			queue.push(intValue(x), nil)
+			pushed <- true
+		}(x)
+	}
+	time.Sleep(50 * time.Millisecond)
+	queue.lock.Lock()
+	queue.values = queue.values[2:]
+	queue.lock.Unlock()
+	wake(queue.space)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pushed:
+		case <-time.After(time.Second):
+			fmt.Println("set still blocked")
+			return
+		}
+	}
+	fmt.Println("queued", queue.len())
+	// Output:
+	// queued 2
+}
+
+func ExampleSetQueue6() {
+	// queued is only a keyword when called
+	runProgramPrintError(`
+var
+  jobs: 4.1.1.7.0 rwb 2 string
+  queued: integer
+endvar
+run
+  queued = queued(jobs) + 1
+  print strInt(queued)
+endrun`)
+	// Output:
+	// 1
+}
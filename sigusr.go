@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifySnapshot asks for SIGUSR1 to be delivered to the channel
+func notifySnapshot(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,7 @@
+package main
+
+import "os"
+
+// notifySnapshot does nothing as there is no SIGUSR1 on windows
+func notifySnapshot(c chan<- os.Signal) {
+}
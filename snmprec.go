@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/PromonLogicalis/asn1"
+	"github.com/PromonLogicalis/snmp"
+)
+
+// Import and export of snmpsim's .snmprec recordings
+// Each line is: oid|tag|value
+//
+// e.g.
+// 1.3.6.1.2.1.1.1.0|4|Toshiba 2555c
+// 1.3.6.1.2.1.25.3.5.1.2.1|4x|2010
+// 1.3.6.1.2.1.43.10.2.1.4.1.1|65|1042
+
+// ASN.1 tags used by snmprec
+const (
+	snmprecInteger   = "2"
+	snmprecString    = "4"
+	snmprecNull      = "5"
+	snmprecOid       = "6"
+	snmprecIpaddress = "64"
+	snmprecCounter   = "65"
+	snmprecGuage     = "66"
+	snmprecTimeticks = "67"
+)
+
+func loadSnmprecFile(filename string) (map[string]*Value, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vals, err := parseSnmprec(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return vals, nil
+}
+
+func parseSnmprec(r io.Reader) (map[string]*Value, error) {
+	vals := make(map[string]*Value)
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "|", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Error at line %d: expecting oid|tag|value", lineNum)
+		}
+		oidStr := "." + strings.TrimPrefix(fields[0], ".")
+		if err := isValidOID(oidStr[1:]); err != nil {
+			return nil, fmt.Errorf("Error at line %d: invalid OID: %v", lineNum, err)
+		}
+
+		val, err := snmprecToValue(fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("Error at line %d: %v", lineNum, err)
+		}
+		if val != nil {
+			vals[oidStr] = val
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// Convert a tag and value into a value
+// Returns nil value for tags we don't serve e.g. Null, Opaque, Counter64
+func snmprecToValue(tag string, text string) (val *Value, err error) {
+	val = new(Value)
+
+	// trailing x means value is hex encoded
+	hexEncoded := strings.HasSuffix(tag, "x")
+	if hexEncoded {
+		tag = strings.TrimSuffix(tag, "x")
+		bytes, err := hex.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid hex value: %v", err)
+		}
+		text = string(bytes)
+	}
+
+	switch tag {
+	case snmprecInteger:
+		val.valueType = ValueInteger
+		val.intVal, err = strconv.Atoi(text)
+	case snmprecString:
+		val.valueType = ValueString
+		val.stringVal = text
+	case snmprecOid:
+		val.valueType = ValueOid
+		val.oidVal = "." + strings.TrimPrefix(text, ".")
+		err = isValidOID(val.oidVal[1:])
+	case snmprecIpaddress:
+		val.valueType = ValueIpv4address
+		val.addrVal = text
+		if hexEncoded {
+			// raw bytes of the address
+			if len(text) != 4 {
+				return nil, fmt.Errorf("Invalid value for tag %s: %d bytes of address", tag, len(text))
+			}
+			val.addrVal = fmt.Sprintf("%d.%d.%d.%d", text[0], text[1], text[2], text[3])
+		}
+		err = isValidIpv4Address(val.addrVal)
+	case snmprecCounter:
+		val.valueType = ValueCounter
+		val.intVal, err = strconv.Atoi(text)
+	case snmprecGuage:
+		val.valueType = ValueGuage
+		val.intVal, err = strconv.Atoi(text)
+	case snmprecTimeticks:
+		val.valueType = ValueTimeticks
+		val.intVal, err = strconv.Atoi(text)
+	default:
+		// unsupported tag
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for tag %s: %v", tag, err)
+	}
+	return val, nil
+}
+
+// Write the current value of every OID in snmprec format
+// Values which can't be encoded, like an unassigned OID, are left out.
+func writeSnmprec(w io.Writer, interp *Interpreter) error {
+	vals := interp.OidValues()
+	for _, oidStr := range sortedOids(vals) {
+		snmpValue, err := valueToSnmp(interp, oidStr, vals[oidStr])
+		if err != nil {
+			logSkipped("export", oidStr, err)
+			continue
+		}
+
+		var tag, text string
+		switch v := snmpValue.(type) {
+		case int:
+			tag, text = snmprecInteger, strconv.Itoa(v)
+		case string:
+			tag, text = snmprecString, v
+			if !isPrintable(v) {
+				tag, text = snmprecString+"x", hex.EncodeToString([]byte(v))
+			}
+		case asn1.Oid:
+			tag, text = snmprecOid, strings.TrimPrefix(v.String(), ".")
+		case snmp.IPAddress:
+			tag, text = snmprecIpaddress, v.String()
+		case snmp.Counter32:
+			tag, text = snmprecCounter, strconv.FormatUint(uint64(v), 10)
+		case snmp.Unsigned32:
+			tag, text = snmprecGuage, strconv.FormatUint(uint64(v), 10)
+		case snmp.TimeTicks:
+			tag, text = snmprecTimeticks, strconv.FormatUint(uint64(v), 10)
+		default:
+			tag, text = snmprecNull, ""
+		}
+
+		_, err = fmt.Fprintf(w, "%s|%s|%s\n", strings.TrimPrefix(oidStr, "."), tag, text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSnmprecFile(filename string, interp *Interpreter) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = writeSnmprec(f, interp)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// isPrintable reports whether the string can be written as plain text
+// in a line based file
+func isPrintable(str string) bool {
+	if !utf8.ValidString(str) {
+		return false
+	}
+	for _, r := range str {
+		if r == '\n' || r == '\r' || r == '|' || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func ExampleSnmprec1() {
+	rec := `# recorded from a Toshiba
+1.3.6.1.2.1.1.1.0|4|Toshiba 2555c
+1.3.6.1.2.1.1.2.0|6|1.3.6.1.4.1.1129.2.3.45.1
+1.3.6.1.2.1.1.3.0|67|21851051
+1.3.6.1.2.1.25.3.5.1.2.1|4x|4c6f77
+1.3.6.1.2.1.43.10.2.1.4.1.1|65|1042
+1.3.6.1.2.1.4.20.1.1.10.100.63.22|64|10.100.63.22
+1.3.6.1.2.1.4.20.1.2.10.100.63.22|2|1
+1.3.6.1.2.1.4.20.1.1.10.100.63.23|64x|0a643f17
+1.3.6.1.2.1.43.11.1.1.9.1.1|66|42
+1.3.6.1.2.1.99.1.0|70|12345678901
+`
+	vals, err := parseSnmprec(strings.NewReader(rec))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printStaticValues(vals)
+	// Output:
+	// .1.3.6.1.2.1.1.1.0: <String: Toshiba 2555c>
+	// .1.3.6.1.2.1.1.2.0: <OID: .1.3.6.1.4.1.1129.2.3.45.1>
+	// .1.3.6.1.2.1.1.3.0: <Timeticks: 21851051>
+	// .1.3.6.1.2.1.25.3.5.1.2.1: <String: Low>
+	// .1.3.6.1.2.1.4.20.1.1.10.100.63.22: <Ipaddress: 10.100.63.22>
+	// .1.3.6.1.2.1.4.20.1.1.10.100.63.23: <Ipaddress: 10.100.63.23>
+	// .1.3.6.1.2.1.4.20.1.2.10.100.63.22: <Integer: 1>
+	// .1.3.6.1.2.1.43.10.2.1.4.1.1: <Counter: 1042>
+	// .1.3.6.1.2.1.43.11.1.1.9.1.1: <Guage: 42>
+}
+
+func ExampleSnmprec2() {
+	prog := `
+var
+  desc: 2.1.1.1.0 string
+  ticks: 2.1.1.3.0 timeticks
+  errors: 2.1.25.3.5.1.2.1 bitset [0 = 'low paper', 2 = 'low toner']
+  pages: 2.1.43.10.2.1.4.1.1 counter
+  status: 2.1.25.3.5.1.1.1 integer
+  object: 2.1.1.2.0 oid
+  host: 2.1.4.20.1.1.10.100.63.22 ipaddress
+  level: 2.1.43.11.1.1.9.1.1 guage
+endvar
+run
+  desc = "Toshiba 2555c"
+  ticks = 1000
+  errors = ['low paper', 'low toner']
+  pages = 1042
+  status = 3
+  object = .1.3.6.1.4.1.1129
+  host = 10.100.63.22
+  level = 42
+endrun`
+	l := lex("test", prog)
+	parser := NewParser(l)
+	program, err := parser.ParseProgram()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	interp := new(Interpreter)
+	interp.Init(program, make(map[string]string))
+	interp.InterpProgram(program)
+
+	err = writeSnmprec(os.Stdout, interp)
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// 1.3.6.1.2.1.1.1.0|4|Toshiba 2555c
+	// 1.3.6.1.2.1.1.2.0|6|1.3.6.1.4.1.1129
+	// 1.3.6.1.2.1.1.3.0|67|1000
+	// 1.3.6.1.2.1.4.20.1.1.10.100.63.22|64|10.100.63.22
+	// 1.3.6.1.2.1.25.3.5.1.1.1|2|3
+	// 1.3.6.1.2.1.25.3.5.1.2.1|4x|a0
+	// 1.3.6.1.2.1.43.10.2.1.4.1.1|65|1042
+	// 1.3.6.1.2.1.43.11.1.1.9.1.1|66|42
+}
+
+func ExampleSnmprec3() {
+	// addresses are only raw bytes in hex values
+	for _, rec := range []string{"1.3.6.1.2.1.4.20.1.1.1|64|1.2.", "1.3.6.1.2.1.4.20.1.1.1|64x|0a64"} {
+		_, err := parseSnmprec(strings.NewReader(rec))
+		fmt.Println(err)
+	}
+
+	// unassigned OIDs and addresses are left out of the export
+	interp := runTestProgram(`
+var
+  desc: 2.1.1.1.0 string
+  object: 2.1.1.2.0 oid
+  host: 2.1.4.20.1.1.10.100.63.22 ipaddress
+endvar
+run
+  desc = "Toshiba 2555c"
+endrun`)
+	err := writeSnmprec(os.Stdout, interp)
+	if err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// Error at line 1: Invalid value for tag 64: Address not 4 fields
+	// Error at line 1: Invalid value for tag 64: 2 bytes of address
+	// 1.3.6.1.2.1.1.1.0|4|Toshiba 2555c
+}
@@ -106,6 +106,108 @@ func convertBytesToOctetStr(values map[string]uint, fieldInfo FieldInfo) (str st
 	return str, nil
 }
 
+// Convert a value into the type the SNMP agent library encodes for the OID
+func valueToSnmp(interp *Interpreter, oidStr string, val *Value) (interface{}, error) {
+	switch val.valueType {
+	case ValueInteger:
+		return val.intVal, nil
+	case ValueCounter:
+		return snmp.Counter32(val.intVal), nil
+	case ValueTimeticks:
+		return snmp.TimeTicks(val.intVal), nil
+	case ValueGuage:
+		return snmp.Unsigned32(val.intVal), nil
+	case ValueString:
+		return val.stringVal, nil
+	case ValueBitset:
+		return convertBitsetToOctetStr(val.bitsetVal), nil
+	case ValueBytes:
+		typ := interp.variables.typesFromOid[oidStr]
+		return convertBytesToOctetStr(val.bytesVal, typ.fieldInfo)
+	case ValueOid:
+		oid, err := strToOID(val.oidVal)
+		if err != nil {
+			return nil, err
+		}
+		return oid, nil
+	case ValueIpv4address:
+		addr, err := strToAddr(val.addrVal)
+		if err != nil {
+			return nil, err
+		}
+		return addr, nil
+	case ValueNone:
+		return nil, errors.New("Illegal Value")
+	}
+	return nil, errors.New("Illegal Value")
+}
+
+// Convert a value set by the SNMP agent library into a value of the given type
+func snmpToValue(valueType ValueType, value interface{}) (*Value, error) {
+	val := new(Value)
+	val.valueType = valueType
+	switch valueType {
+	case ValueString:
+		switch value.(type) {
+		case string:
+			val.stringVal = value.(string)
+		default:
+			return nil, errors.New("Bad string type")
+		}
+	case ValueInteger:
+		switch value.(type) {
+		case int:
+			val.intVal = value.(int)
+		default:
+			return nil, errors.New("Bad int type")
+		}
+	case ValueCounter:
+		// Apparently one is not allowed to set a counter
+		return nil, errors.New("Cannot set counter type")
+	case ValueBytes:
+		return nil, errors.New("Not supporting set bytes yet")
+	case ValueTimeticks:
+		switch value.(type) {
+		case snmp.TimeTicks:
+			val.intVal = int(value.(snmp.TimeTicks))
+		default:
+			return nil, errors.New("Bad time ticks type")
+		}
+	case ValueGuage:
+		switch value.(type) {
+		case snmp.Unsigned32:
+			val.intVal = int(value.(snmp.Unsigned32))
+		default:
+			return nil, errors.New("Bad guage type")
+		}
+	case ValueOid:
+		switch value.(type) {
+		case asn1.Oid:
+			oid := value.(asn1.Oid)
+			val.oidVal = oid.String()
+		default:
+			return nil, errors.New("Bad OID type")
+		}
+	case ValueIpv4address:
+		switch value.(type) {
+		case snmp.IPAddress:
+			addr := value.(snmp.IPAddress)
+			val.addrVal = addr.String()
+		default:
+			return nil, errors.New("Bad ip address type")
+		}
+	case ValueBitset:
+		switch value.(type) {
+		case string:
+			str := value.(string)
+			val.bitsetVal = convertOctetStrToBitset(str)
+		default:
+			return nil, errors.New("Bad bitset type")
+		}
+	}
+	return val, nil
+}
+
 func addOIDFunc(agent *snmp.Agent, interp *Interpreter, strOid string, snmpMode SnmpMode) {
 	if len(strOid) == 0 {
 		logger.Println("Empty oid")
@@ -117,166 +219,253 @@ func addOIDFunc(agent *snmp.Agent, interp *Interpreter, strOid string, snmpMode
 		return
 	}
 
-	// given OID store away the provided value
-	writeFunc := func(oid asn1.Oid, value interface{}) error {
-		val := new(Value)
+	switch snmpMode {
+	case SnmpModeRead:
+		agent.AddRoManagedObject(oid, oidReadFunc(interp))
+	case SnmpModeReadWrite, SnmpModeReadWriteBlocked:
+		agent.AddRwManagedObject(oid, oidReadFunc(interp), oidWriteFunc(interp, snmpMode))
+	}
+}
+
+// oidWriteFunc gets the agent's function storing away the value set for an OID
+func oidWriteFunc(interp *Interpreter, snmpMode SnmpMode) func(oid asn1.Oid, value interface{}) error {
+	return func(oid asn1.Oid, value interface{}) error {
 		oidStr := oid.String()
-		typ := interp.variables.typesFromOid[oidStr]
-		switch typ.valueType {
-		case ValueString:
-			switch value.(type) {
-			case string:
-				val.stringVal = value.(string)
-			default:
-				return errors.New("Bad string type")
-			}
-		case ValueInteger:
-			switch value.(type) {
-			case int:
-				val.intVal = value.(int)
-			default:
-				return errors.New("Bad int type")
-			}
-		case ValueCounter:
-			// Apparently one is not allowed to set a counter
-			return errors.New("Cannot set counter type")
-		case ValueBytes:
-			return errors.New("Not supporting set bytes yet")
-		case ValueTimeticks:
-			switch value.(type) {
-			case snmp.TimeTicks:
-				val.intVal = int(value.(snmp.TimeTicks))
-			default:
-				return errors.New("Bad time ticks type")
-			}
-		case ValueGuage:
-			switch value.(type) {
-			case snmp.Unsigned32:
-				val.intVal = int(value.(snmp.Unsigned32))
-			default:
-				return errors.New("Bad guage type")
+		typ, ok := interp.variables.typesFromOid[oidStr]
+		if !ok {
+			// writable static value such as sysContact
+			cur, _ := interp.GetValueForOid(oidStr)
+			val, err := snmpToValue(cur.valueType, value)
+			if err != nil {
+				return err
 			}
-		case ValueOid:
-			switch value.(type) {
-			case asn1.Oid:
-				oid := value.(asn1.Oid)
-				val.oidVal = oid.String()
-			default:
-				return errors.New("Bad OID type")
-			}
-		case ValueIpv4address:
-			switch value.(type) {
-			case snmp.IPAddress:
-				addr := value.(snmp.IPAddress)
-				val.addrVal = addr.String()
-			default:
-				return errors.New("Bad ip address type")
-			}
-		case ValueBitset:
-			switch value.(type) {
-			case string:
-				str := value.(string)
-				val.bitsetVal = convertOctetStrToBitset(str)
-			default:
-				return errors.New("Bad bitset type")
+			if oidStr == snmpEnableAuthenTrapsOid && !isAuthenTrapsValue(val.intVal) {
+				return errors.New("Wrong value")
 			}
+			interp.SetStaticValue(oidStr, val)
+			return nil
+		}
+		val, err := snmpToValue(typ.valueType, value)
+		if err != nil {
+			return err
 		}
 
 		//fmt.Printf("received value of %v for oid %s\n", val, oidStr)
 		switch snmpMode {
 		case SnmpModeReadWrite:
 			// update variable data under locking
-			interp.SetValueForIdOid(typ.id, oidStr, val)
+			oldVal := interp.swapValueForIdOid(typ.id, oidStr, val)
+			interp.notifySet(typ.id, oldVal, val)
+
+			// wake any read statement waiting on it
+			select {
+			case typ.externalValue <- val:
+			default:
+			}
 		case SnmpModeReadWriteBlocked:
-			// use a blocking channel to send data
-			typ.externalValue <- val
+			// queue the value for the program to read
+			err = interp.setQueues[typ.id].push(val, interp.stop)
+			if err != nil {
+				return err
+			}
+			interp.changes.notify() // for any wait until on queued()
 		}
 
 		return nil
 	}
+}
 
-	// given OID return its value
-	readFunc := func(oid asn1.Oid) (interface{}, error) {
+// oidReadFunc gets the agent's function returning the value of an OID
+func oidReadFunc(interp *Interpreter) func(oid asn1.Oid) (interface{}, error) {
+	return func(oid asn1.Oid) (interface{}, error) {
 		oidStr := oid.String()
+		interp.pollOid(oidStr)
 		//fmt.Printf("callback: oid: %s\n", oidStr)
 		//fmt.Printf("oid values: %v\n", interp.oid2Values)
 		val, found := interp.GetValueForOid(oidStr)
 		if !found {
 			return nil, errors.New("Illegal Value")
 		}
-		switch val.valueType {
-		case ValueInteger:
-			return val.intVal, nil
-		case ValueCounter:
-			return snmp.Counter32(val.intVal), nil
-		case ValueTimeticks:
-			return snmp.TimeTicks(val.intVal), nil
-		case ValueGuage:
-			return snmp.Unsigned32(val.intVal), nil
-		case ValueString:
-			return val.stringVal, nil
-		case ValueBitset:
-			return convertBitsetToOctetStr(val.bitsetVal), nil
-		case ValueBytes:
-			typ := interp.variables.typesFromOid[oidStr]
-			return convertBytesToOctetStr(val.bytesVal, typ.fieldInfo)
-		case ValueOid:
-			oid, err := strToOID(val.oidVal)
-			if err != nil {
-				return nil, err
-			}
-			return oid, nil
-		case ValueIpv4address:
-			addr, err := strToAddr(val.addrVal)
-			if err != nil {
-				return nil, err
-			}
-			return addr, nil
-		case ValueNone:
-			return nil, errors.New("Illegal Value")
-		}
-		return nil, errors.New("Illegal Value")
+		interp.polled(oidStr)
+		return valueToSnmp(interp, oidStr, val)
 	}
+}
 
-	switch snmpMode {
-	case SnmpModeRead:
-		agent.AddRoManagedObject(oid, readFunc)
-	case SnmpModeReadWrite, SnmpModeReadWriteBlocked:
-		agent.AddRwManagedObject(oid, readFunc, writeFunc)
+// SnmpServer answers SNMP requests for the interpreter's OIDs
+type SnmpServer struct {
+	conn          *net.UDPConn
+	interp        *Interpreter
+	stats         *SnmpStats
+	communities   Communities
+	trapCommunity string
+	trapAddr      *net.UDPAddr // nil if not sending traps
+	audit         *AuditLog    // nil if not auditing
+	pcap          *PcapWriter  // nil if not capturing
+}
+
+// capture records a datagram in any packet capture
+func (server *SnmpServer) capture(src net.Addr, dst net.Addr, datagram []byte) {
+	if server.pcap == nil {
+		return
+	}
+	err := server.pcap.write(time.Now(), src, dst, datagram)
+	if err != nil {
+		logger.Printf("Failed to write packet capture: %s\n", err)
 	}
 }
 
-func initSNMPServer(interp *Interpreter, portNum uint, readCommunity string, writeCommunity string) (agent *snmp.Agent, conn *net.UDPConn, err error) {
-	agent = snmp.NewAgent()
+func initSNMPServer(interp *Interpreter, stats *SnmpStats, portNum uint, communities Communities, trapCommunity string,
+	trapDest string) (server *SnmpServer, err error) {
+	server = &SnmpServer{
+		interp:        interp,
+		stats:         stats,
+		communities:   communities,
+		trapCommunity: trapCommunity,
+	}
 
-	// Set the read-only and read-write communities
-	agent.SetCommunities(readCommunity, writeCommunity)
+	if trapDest != "" {
+		if _, _, err := net.SplitHostPort(trapDest); err != nil {
+			trapDest = net.JoinHostPort(trapDest, "162")
+		}
+		server.trapAddr, err = net.ResolveUDPAddr("udp", trapDest)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Bind to an UDP port
 	portStr := ":" + strconv.FormatUint(uint64(portNum), 10)
 	addr, err := net.ResolveUDPAddr("udp", portStr)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	conn, err = net.ListenUDP("udp", addr)
+	server.conn, err = net.ListenUDP("udp", addr)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	if err := enablePktinfo(server.conn); err != nil {
+		logger.Printf("Unable to read datagram destinations: %s\n", err)
 	}
 
-	//fmt.Printf("oid2Values: %v\n", interp.oid2Values)
-	for oidStr := range interp.oid2Values {
-		addOIDFunc(agent, interp, oidStr, interp.variables.typesFromOid[oidStr].snmpMode)
+	// each community has an agent serving its view
+	for _, community := range communities {
+		community.initAgent(interp)
 	}
 
-	return agent, conn, err
+	return server, err
+}
+
+// readDatagram reads one incoming message with the address it came from and the address it was sent to
+// Without packet info the destination is the unspecified address of the source's family.
+func (server *SnmpServer) readDatagram(buffer []byte) (n int, source *net.UDPAddr, dest *net.UDPAddr, err error) {
+	oob := make([]byte, 128)
+	n, oobn, _, source, err := server.conn.ReadMsgUDP(buffer, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	ip := pktinfoDest(oob[:oobn])
+	if ip == nil {
+		ip = net.IPv6unspecified
+		if source.IP.To4() != nil {
+			ip = net.IPv4zero
+		}
+	}
+	dest = &net.UDPAddr{IP: ip, Port: server.conn.LocalAddr().(*net.UDPAddr).Port}
+	return n, source, dest, nil
+}
+
+// processDatagram handles one incoming message returning any response
+// Messages failing authentication are dropped after being counted.
+func (server *SnmpServer) processDatagram(datagram []byte, source net.Addr) (response []byte, err error) {
+	var request *SnmpPacket
+	if server.audit != nil {
+		start := time.Now()
+		defer func() {
+			server.audit.log(start, time.Since(start), source, request, response, err)
+		}()
+	}
+
+	server.stats.incr(snmpInPkts)
+
+	request, err = decodeSnmpPacket(datagram)
+	if err != nil {
+		server.stats.incr(snmpInASNParseErrs)
+		return nil, err
+	}
+	community, err := server.authenticate(request, source)
+	if err != nil {
+		return nil, err
+	}
+	server.stats.countRequest(request)
+
+	// the agent can't tell us apart a read-only community or an OID outside the view
+	if request.pduType == pduSetRequest {
+		if !community.writable {
+			server.stats.incr(snmpInBadCommunityUses)
+			server.sendAuthenticationFailure()
+			return server.errorResponse(request, errAuthorizationError, 0)
+		}
+		for i, vb := range request.varbinds {
+			if !community.inView(vb.oid) {
+				return server.errorResponse(request, errNoAccess, i+1)
+			}
+		}
+		if i := wrongAuthenTrapsSet(request.varbinds); i >= 0 {
+			return server.errorResponse(request, errWrongValue, i+1)
+		}
+		if i := server.interp.rejectedSet(request.varbinds); i >= 0 {
+			return server.errorResponse(request, errResourceUnavailable, i+1)
+		}
+	}
+
+	server.interp.SetRequester(source)
+	response, err = community.agent.ProcessDatagram(datagram)
+	if err != nil {
+		return nil, err
+	}
+	if responsePacket, err := decodeSnmpPacket(response); err == nil {
+		server.stats.countResponse(request, responsePacket)
+	}
+	return response, nil
+}
+
+// errorResponse makes a response to the request with the v2c error status
+func (server *SnmpServer) errorResponse(request *SnmpPacket, errorStatus int, errorIndex int) ([]byte, error) {
+	response := *request
+	response.pduType = pduGetResponse
+	response.errorStatus = errorStatus
+	response.errorIndex = errorIndex
+	if request.version == snmpVersion1 {
+		response.errorStatus = v1ErrorStatus(errorStatus)
+		if response.errorIndex == 0 {
+			response.errorIndex = 1
+		}
+	}
+	server.stats.countResponse(request, &response)
+	return encodeSnmpPacket(&response)
+}
+
+// v1ErrorStatus maps a v2c error status to v1 (RFC 3584)
+func v1ErrorStatus(errorStatus int) int {
+	switch errorStatus {
+	case errNoAccess, errAuthorizationError:
+		return errNoSuchName
+	case errResourceUnavailable:
+		return errGenErr
+	case errWrongValue:
+		return errBadValue
+	}
+	return errorStatus
 }
 
 // Read from a channel about OID requests
-func runSNMPServer(agent *snmp.Agent, conn *net.UDPConn, quit chan bool, wg *sync.WaitGroup) {
+func runSNMPServer(server *SnmpServer, quit chan bool, wg *sync.WaitGroup) {
 	const readTimeoutSecs = 5
 
 	defer wg.Done()
 
+	conn := server.conn
+
 	// Serve requests
 	for {
 
@@ -291,7 +480,7 @@ func runSNMPServer(agent *snmp.Agent, conn *net.UDPConn, quit chan bool, wg *syn
 		// read incoming PDU
 		buffer := make([]byte, 1024)
 		conn.SetReadDeadline(time.Now().Add(readTimeoutSecs * time.Second))
-		n, source, err := conn.ReadFrom(buffer)
+		n, source, dest, err := server.readDatagram(buffer)
 		if err != nil {
 			if e, ok := err.(net.Error); !ok || !e.Timeout() {
 				// error but not a network error or a network error other than timeout
@@ -303,10 +492,12 @@ func runSNMPServer(agent *snmp.Agent, conn *net.UDPConn, quit chan bool, wg *syn
 			continue
 		}
 
+		server.capture(source, dest, buffer[:n])
+
 		// process PDU
-		buffer, err = agent.ProcessDatagram(buffer[:n])
+		buffer, err = server.processDatagram(buffer[:n], source)
 		if err != nil {
-			logger.Println(err)
+			logger.Printf("%s: %s\n", source, err)
 			continue
 		}
 
@@ -316,6 +507,7 @@ func runSNMPServer(agent *snmp.Agent, conn *net.UDPConn, quit chan bool, wg *syn
 			logger.Printf("Failed to write buffer: %s", err)
 			os.Exit(1)
 		}
+		server.capture(dest, source, buffer)
 	}
 }
 
@@ -338,20 +530,40 @@ func (varInits *VariableInits) Set(value string) error {
 
 var version string // to be overridden with ldflags
 
-// snmprun -p 161 -c public -C private -V key='value'
+// snmprun -p 161 -c public -C private -community 'monitor=ro;view=1.3.6.1.2.1;from=10.0.0.0/8' -dump device.walk -snmprec device.snmprec -export final.snmprec
+// -S sysName='value' -trap manager:162 -authtraps -audit audit.json -pcap snmp.pcap -V key='value'
 func main() {
-	var portNum uint           // -p 161
-	var readCommunity string   // -c public
-	var writeCommunity string  // -C private
-	var versionFlag bool       // -v
-	var varInits VariableInits // -V key1=val1 -V key2=val2
+	var portNum uint            // -p 161
+	var readCommunity string    // -c public
+	var writeCommunity string   // -C private
+	var versionFlag bool        // -v
+	var dumpFilename string     // -dump device.walk
+	var snmprecFilename string  // -snmprec device.snmprec
+	var exportFilename string   // -export final.snmprec
+	var communities Communities // -community name=rw;view=1.3.6.1.2.1,-1.3.6.1.2.1.4;from=10.0.0.0/8
+	var trapDest string         // -trap manager:162
+	var auditFilename string    // -audit audit.json
+	var pcapFilename string     // -pcap snmp.pcap
+	var authTraps bool          // -authtraps
+	var varInits VariableInits  // -V key1=val1 -V key2=val2
+	var sysInits VariableInits  // -S sysName=printer1 -S sysLocation=office
 	varInits = make(map[string]string)
+	sysInits = make(map[string]string)
 
 	flag.UintVar(&portNum, "p", 161, "port number for SNMP server")
-	flag.StringVar(&readCommunity, "c", "public", "community name")
-	flag.StringVar(&writeCommunity, "C", "private", "community name")
+	flag.StringVar(&readCommunity, "c", "public", "read-only community name if no -community given")
+	flag.StringVar(&writeCommunity, "C", "private", "read-write community name if no -community given")
 	flag.BoolVar(&versionFlag, "v", false, "print version number")
+	flag.StringVar(&dumpFilename, "dump", "", "snmpwalk -On output to serve as read-only base MIB")
+	flag.StringVar(&snmprecFilename, "snmprec", "", "snmprec recording to serve as read-only base MIB")
+	flag.StringVar(&exportFilename, "export", "", "snmprec file to write all OID values to when the program ends")
+	flag.Var(&communities, "community", "community with its access, view and sources e.g. name=rw;view=1.3.6.1.2.1,-1.3.6.1.2.1.4;from=10.0.0.0/8")
+	flag.StringVar(&auditFilename, "audit", "", "file to append a JSON line to for every request")
+	flag.StringVar(&pcapFilename, "pcap", "", "pcap file to capture the SNMP datagrams to")
+	flag.StringVar(&trapDest, "trap", "", "host[:port] to send traps to")
+	flag.BoolVar(&authTraps, "authtraps", false, "enable authenticationFailure traps")
 	flag.Var(&varInits, "V", "variable initializers")
+	flag.Var(&sysInits, "S", "system group initializers e.g. sysName=printer1")
 	flag.Parse()
 
 	if versionFlag {
@@ -394,23 +606,91 @@ func main() {
 	interp := new(Interpreter)
 	interp.Init(program, varInits)
 
-	agent, conn, err := initSNMPServer(interp, portNum, readCommunity, writeCommunity)
+	if dumpFilename != "" {
+		dumpValues, err := loadWalkFile(dumpFilename)
+		if err != nil {
+			fmt.Printf("Unable to load dump: %s\n", err)
+			os.Exit(1)
+		}
+		interp.LoadStaticValues(dumpValues)
+	}
+
+	if snmprecFilename != "" {
+		recValues, err := loadSnmprecFile(snmprecFilename)
+		if err != nil {
+			fmt.Printf("Unable to load snmprec: %s\n", err)
+			os.Exit(1)
+		}
+		interp.LoadStaticValues(recValues)
+	}
+
+	err = interp.AddSystemGroup(time.Now(), sysInits)
+	if err != nil {
+		fmt.Printf("Failed to init system group: %s\n", err)
+		os.Exit(1)
+	}
+
+	stats := new(SnmpStats)
+	interp.AddSnmpGroup(stats, authTraps)
+
+	if len(communities) == 0 {
+		communities = Communities{
+			{name: readCommunity},
+			{name: writeCommunity, writable: true},
+		}
+	}
+	server, err := initSNMPServer(interp, stats, portNum, communities, readCommunity, trapDest)
 	if err != nil {
 		fmt.Printf("Failed to init snmp server: %s\n", err)
 		os.Exit(1)
 	}
 
+	if auditFilename != "" {
+		server.audit, err = openAuditLog(auditFilename)
+		if err != nil {
+			fmt.Printf("Unable to open audit log: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if pcapFilename != "" {
+		server.pcap, err = openPcapFile(pcapFilename)
+		if err != nil {
+			fmt.Printf("Unable to open packet capture: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// SIGUSR1 writes a snapshot of the MIB next to the program
+	snapshotSignals := make(chan os.Signal, 1)
+	notifySnapshot(snapshotSignals)
+	go func() {
+		for range snapshotSignals {
+			err := writeWalkFile(filename+".walk", interp)
+			if err != nil {
+				logger.Printf("Failed to write snapshot: %s\n", err)
+			}
+		}
+	}()
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 	quitServer := make(chan bool)
 	// SNMP server running in background
-	go runSNMPServer(agent, conn, quitServer, &wg)
+	go runSNMPServer(server, quitServer, &wg)
 
 	// now run program to set the OID values
 	err = interp.InterpProgram(program)
 	if err != nil {
 		logger.Printf("Interpreting error: %s\n", err)
 	}
+
+	if exportFilename != "" {
+		err = writeSnmprecFile(exportFilename, interp)
+		if err != nil {
+			fmt.Printf("Unable to export snmprec: %s\n", err)
+		}
+	}
 	quitServer <- true
 
 	wg.Wait()
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+// SNMP group (RFC 3418) of statistics about the agent itself
+// The counters are updated by the server loop and read by managers,
+// snapshots and exports, so they are accessed atomically.
+
+const (
+	snmpOid                  = ".1.3.6.1.2.1.11"
+	snmpEnableAuthenTrapsOid = snmpOid + ".30.0"
+)
+
+// sub-identifiers of the counters under snmpOid
+const (
+	snmpInPkts              = 1
+	snmpOutPkts             = 2
+	snmpInBadVersions       = 3
+	snmpInBadCommunityNames = 4
+	snmpInBadCommunityUses  = 5
+	snmpInASNParseErrs      = 6
+	snmpInTooBigs           = 8
+	snmpInNoSuchNames       = 9
+	snmpInBadValues         = 10
+	snmpInReadOnlys         = 11
+	snmpInGenErrs           = 12
+	snmpInTotalReqVars      = 13
+	snmpInTotalSetVars      = 14
+	snmpInGetRequests       = 15
+	snmpInGetNexts          = 16
+	snmpInSetRequests       = 17
+	snmpInGetResponses      = 18
+	snmpInTraps             = 19
+	snmpOutTooBigs          = 20
+	snmpOutNoSuchNames      = 21
+	snmpOutBadValues        = 22
+	snmpOutGenErrs          = 24
+	snmpOutGetRequests      = 25
+	snmpOutGetNexts         = 26
+	snmpOutSetRequests      = 27
+	snmpOutGetResponses     = 28
+	snmpOutTraps            = 29
+	snmpSilentDrops         = 31
+	snmpProxyDrops          = 32
+	snmpMaxCounter          = snmpProxyDrops
+)
+
+// snmpEnableAuthenTraps values
+const (
+	authenTrapsEnabled  = 1
+	authenTrapsDisabled = 2
+)
+
+// generic-trap values of v1 traps
+const (
+	genericAuthenticationFailure = 4
+)
+
+type SnmpStats struct {
+	counters [snmpMaxCounter + 1]uint32
+}
+
+func (stats *SnmpStats) incr(counter int) {
+	atomic.AddUint32(&stats.counters[counter], 1)
+}
+
+func (stats *SnmpStats) add(counter int, n int) {
+	atomic.AddUint32(&stats.counters[counter], uint32(n))
+}
+
+func (stats *SnmpStats) get(counter int) uint32 {
+	return atomic.LoadUint32(&stats.counters[counter])
+}
+
+// countRequest counts an authenticated incoming message by PDU type
+func (stats *SnmpStats) countRequest(request *SnmpPacket) {
+	switch request.pduType {
+	case pduGetRequest:
+		stats.incr(snmpInGetRequests)
+	case pduGetNextRequest:
+		stats.incr(snmpInGetNexts)
+	case pduSetRequest:
+		stats.incr(snmpInSetRequests)
+	case pduGetResponse:
+		stats.incr(snmpInGetResponses)
+	case pduTrapV1, pduTrapV2:
+		stats.incr(snmpInTraps)
+	}
+}
+
+// countResponse counts the response the agent made to a request
+func (stats *SnmpStats) countResponse(request *SnmpPacket, response *SnmpPacket) {
+	stats.incr(snmpOutPkts)
+	if response.pduType == pduGetResponse {
+		stats.incr(snmpOutGetResponses)
+	}
+	switch response.errorStatus {
+	case errNoError:
+		switch request.pduType {
+		case pduGetRequest, pduGetNextRequest, pduGetBulkRequest:
+			stats.add(snmpInTotalReqVars, len(response.varbinds))
+		case pduSetRequest:
+			stats.add(snmpInTotalSetVars, len(request.varbinds))
+		}
+	case errTooBig:
+		stats.incr(snmpOutTooBigs)
+	case errNoSuchName:
+		stats.incr(snmpOutNoSuchNames)
+	case errBadValue:
+		stats.incr(snmpOutBadValues)
+	case errGenErr:
+		stats.incr(snmpOutGenErrs)
+	}
+}
+
+// AddSnmpGroup adds the SNMP group counters to the served OIDs
+func (interp *Interpreter) AddSnmpGroup(stats *SnmpStats, enableAuthenTraps bool) {
+	for counter := snmpInPkts; counter <= snmpMaxCounter; counter++ {
+		if counter == 7 || counter == 23 || counter == 30 {
+			continue // not used or not a counter
+		}
+		counter := counter
+		oidStr := snmpOid + "." + strconv.Itoa(counter) + ".0"
+		interp.AddLiveValue(oidStr, ValueCounter, func() *Value {
+			return &Value{valueType: ValueCounter, intVal: int(stats.get(counter))}
+		})
+	}
+
+	enabled := authenTrapsDisabled
+	if enableAuthenTraps {
+		enabled = authenTrapsEnabled
+	}
+	interp.AddStaticValue(snmpEnableAuthenTrapsOid, &Value{valueType: ValueInteger, intVal: enabled}, SnmpModeReadWrite)
+}
+
+// isAuthenTrapsValue reports whether the value is enabled(1) or disabled(2) (RFC 3418)
+func isAuthenTrapsValue(x int) bool {
+	return x == authenTrapsEnabled || x == authenTrapsDisabled
+}
+
+// wrongAuthenTrapsSet finds a varbind of a set request giving snmpEnableAuthenTraps
+// a value other than enabled or disabled, returning its index or -1 if there is none
+func wrongAuthenTrapsSet(varbinds []SnmpVarbind) int {
+	for i, vb := range varbinds {
+		if vb.oid != snmpEnableAuthenTrapsOid {
+			continue
+		}
+		if x, ok := vb.value.(int); ok && !isAuthenTrapsValue(x) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sendAuthenticationFailure sends a v1 authenticationFailure trap
+// if there is a trap destination and a manager has not disabled them
+func (server *SnmpServer) sendAuthenticationFailure() {
+	if server.trapAddr == nil {
+		return
+	}
+	val, found := server.interp.GetValueForOid(snmpEnableAuthenTrapsOid)
+	if !found || val.intVal != authenTrapsEnabled {
+		return
+	}
+	err := server.sendTrapV1(genericAuthenticationFailure, 0, nil)
+	if err != nil {
+		logger.Printf("Failed to send authenticationFailure trap: %s\n", err)
+	}
+}
+
+func (server *SnmpServer) sendTrapV1(genericTrap int, specificTrap int, varbinds []SnmpVarbind) error {
+	conn, err := net.DialUDP("udp", nil, server.trapAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the agent address is the one we send from
+	var agentAddr [4]byte
+	if localAddr, ok := conn.LocalAddr().(*net.UDPAddr); ok && localAddr.IP.To4() != nil {
+		copy(agentAddr[:], localAddr.IP.To4())
+	}
+
+	enterprise := ".0.0"
+	if val, found := server.interp.GetValueForOid(sysObjectIDOid); found && val.valueType == ValueOid {
+		enterprise = val.oidVal
+	}
+	var ticks uint
+	if val, found := server.interp.GetValueForOid(sysUpTimeOid); found {
+		ticks = uint(val.intVal)
+	}
+
+	trap, err := encodeTrapV1(server.trapCommunity, enterprise, agentAddr, genericTrap, specificTrap, ticks, varbinds)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(trap)
+	if err != nil {
+		return err
+	}
+	server.capture(conn.LocalAddr(), server.trapAddr, trap)
+	server.stats.incr(snmpOutPkts)
+	server.stats.incr(snmpOutTraps)
+	return nil
+}
+
+// authenticate checks the version and community of a request
+// returning the community or counting the failure
+func (server *SnmpServer) authenticate(request *SnmpPacket, source net.Addr) (*Community, error) {
+	if request.version != snmpVersion1 && request.version != snmpVersion2c {
+		server.stats.incr(snmpInBadVersions)
+		return nil, errors.New("Unsupported SNMP version")
+	}
+	community := findCommunity(server.communities, request.community, source)
+	if community == nil {
+		server.stats.incr(snmpInBadCommunityNames)
+		server.sendAuthenticationFailure()
+		return nil, errors.New("Authentication failure for community " + strconv.Quote(request.community))
+	}
+	return community, nil
+}
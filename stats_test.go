@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+func ExampleStats1() {
+	interp := runTestProgram(`
+var
+  desc: 2.1.1.1.0 string
+endvar
+run
+  desc = "Toshiba 2555c"
+endrun`)
+	stats := new(SnmpStats)
+	interp.AddSnmpGroup(stats, true)
+	server := &SnmpServer{interp: interp, stats: stats, communities: Communities{{name: "public"}}}
+	source := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1161}
+
+	// don't need an agent to check a good request
+	get := &SnmpPacket{version: snmpVersion1, community: "public", pduType: pduGetRequest}
+	community, err := server.authenticate(get, source)
+	fmt.Println(community.name, err)
+
+	set := &SnmpPacket{version: snmpVersion2c, community: "public", pduType: pduSetRequest}
+	bad := &SnmpPacket{version: snmpVersion2c, community: "secret", pduType: pduGetRequest}
+	v3 := &SnmpPacket{version: 3}
+	for _, request := range []*SnmpPacket{set, bad, v3} {
+		datagram, err := encodeSnmpPacket(request)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		_, err = server.processDatagram(datagram, source)
+		fmt.Println(err)
+	}
+	_, err = server.processDatagram([]byte{0x30, 0x03, 0x02}, source)
+	fmt.Println(err)
+
+	for _, counter := range []int{snmpInPkts, snmpInBadVersions, snmpInBadCommunityNames, snmpInBadCommunityUses, snmpInASNParseErrs} {
+		val, _ := interp.GetValueForOid(fmt.Sprintf("%s.%d.0", snmpOid, counter))
+		fmt.Println(val)
+	}
+	val, _ := interp.GetValueForOid(snmpEnableAuthenTrapsOid)
+	fmt.Println(val, interp.staticModes[snmpEnableAuthenTrapsOid])
+	// Output:
+	// public <nil>
+	// <nil>
+	// Authentication failure for community "secret"
+	// Unsupported SNMP version
+	// BER length past end of data
+	// <Counter: 4>
+	// <Counter: 1>
+	// <Counter: 1>
+	// <Counter: 1>
+	// <Counter: 1>
+	// <Integer: 1> 1
+}
+
+func ExampleStats2() {
+	interp := runTestProgram(`
+var
+  desc: 2.1.1.1.0 string
+endvar
+run
+  desc = "Toshiba 2555c"
+endrun`)
+	stats := new(SnmpStats)
+	interp.AddSnmpGroup(stats, true)
+	communities := Communities{{name: "private", writable: true}}
+	server := &SnmpServer{interp: interp, stats: stats, communities: communities}
+	source := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1161}
+
+	// only enabled(1) and disabled(2) can be set
+	for _, request := range []*SnmpPacket{
+		{version: snmpVersion1, community: "private", pduType: pduSetRequest, requestId: 1},
+		{version: snmpVersion2c, community: "private", pduType: pduSetRequest, requestId: 2},
+	} {
+		request.varbinds = []SnmpVarbind{{snmpEnableAuthenTrapsOid, berInteger, 3}}
+		datagram, err := encodeSnmpPacket(request)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		datagram, err = server.processDatagram(datagram, source)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		response, err := decodeSnmpPacket(datagram)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%d: %s error: %d/%d\n", response.requestId, pduNames[response.pduType], response.errorStatus, response.errorIndex)
+	}
+	val, _ := interp.GetValueForOid(snmpEnableAuthenTrapsOid)
+	fmt.Println(val)
+	// Output:
+	// 1: response error: 3/1
+	// 2: response error: 10/1
+	// <Integer: 1>
+}
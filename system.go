@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Built-in MIB-2 system group (RFC 3418) served for every program
+// Its values can be set from the command line with -S name=value,
+// otherwise come from any loaded dump or else a default.
+// Any of its OIDs declared in the program's var section override it.
+
+const (
+	sysOid           = ".1.3.6.1.2.1.1"
+	sysDescrOid      = sysOid + ".1.0"
+	sysObjectIDOid   = sysOid + ".2.0"
+	sysUpTimeOid     = sysOid + ".3.0"
+	sysContactOid    = sysOid + ".4.0"
+	sysNameOid       = sysOid + ".5.0"
+	sysLocationOid   = sysOid + ".6.0"
+	sysServicesOid   = sysOid + ".7.0"
+	sysORLastChgOid  = sysOid + ".8.0"
+	sysOREntryOid    = sysOid + ".9.1"
+	snmpMIBModuleOid = ".1.3.6.1.6.3.1" // SNMPv2-MIB which we implement
+)
+
+type SystemVar struct {
+	oid       string
+	valueType ValueType
+	snmpMode  SnmpMode
+}
+
+// system variables settable with -S
+var systemVars = map[string]SystemVar{
+	"sysDescr":    {sysDescrOid, ValueString, SnmpModeRead},
+	"sysObjectID": {sysObjectIDOid, ValueOid, SnmpModeRead},
+	"sysUpTime":   {sysUpTimeOid, ValueTimeticks, SnmpModeRead},
+	"sysContact":  {sysContactOid, ValueString, SnmpModeReadWrite},
+	"sysName":     {sysNameOid, ValueString, SnmpModeReadWrite},
+	"sysLocation": {sysLocationOid, ValueString, SnmpModeReadWrite},
+	"sysServices": {sysServicesOid, ValueInteger, SnmpModeRead},
+}
+
+// AddSystemGroup adds the system group to the served OIDs
+// sysUpTime counts up from the start time
+func (interp *Interpreter) AddSystemGroup(startTime time.Time, sysInits VariableInits) error {
+	hostname, _ := os.Hostname()
+	sysVersion := version
+	if sysVersion == "" {
+		sysVersion = "devel"
+	}
+	defaults := map[string]*Value{
+		"sysDescr":    {valueType: ValueString, stringVal: "SNMPrun simulator " + sysVersion},
+		"sysObjectID": {valueType: ValueOid, oidVal: ".0.0"}, // zeroDotZero - unknown
+		"sysUpTime":   {valueType: ValueTimeticks},
+		"sysContact":  {valueType: ValueString},
+		"sysName":     {valueType: ValueString, stringVal: hostname},
+		"sysLocation": {valueType: ValueString},
+		"sysServices": {valueType: ValueInteger, intVal: 72}, // applications and end-to-end
+	}
+
+	// a dump's values are preferred to the defaults
+	for name, sysVar := range systemVars {
+		if val, found := interp.GetValueForOid(sysVar.oid); found && val.valueType == sysVar.valueType {
+			defaults[name] = val
+		}
+	}
+
+	for name, text := range sysInits {
+		sysVar, ok := systemVars[name]
+		if !ok {
+			return fmt.Errorf("Unknown system variable: %s", name)
+		}
+		if sysVar.valueType == ValueOid {
+			text = strings.TrimPrefix(text, ".")
+		}
+		val := &Value{valueType: sysVar.valueType}
+		err := textToValue(text, val, interp.variables)
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %v", name, err)
+		}
+		if sysVar.valueType == ValueOid {
+			val.oidVal = "." + val.oidVal
+		}
+		defaults[name] = val
+	}
+
+	for name, sysVar := range systemVars {
+		if name == "sysUpTime" {
+			continue
+		}
+		interp.AddStaticValue(sysVar.oid, defaults[name], sysVar.snmpMode)
+	}
+
+	// uptime carries on from any initial value given
+	initialTicks := defaults["sysUpTime"].intVal
+	interp.AddLiveValue(sysUpTimeOid, ValueTimeticks, func() *Value {
+		ticks := initialTicks + int(time.Since(startTime)/(10*time.Millisecond))
+		return &Value{valueType: ValueTimeticks, intVal: ticks}
+	})
+
+	// sysORTable lists the one MIB module we support and never changes
+	interp.AddStaticValue(sysORLastChgOid, &Value{valueType: ValueTimeticks}, SnmpModeRead)
+	interp.AddStaticValue(sysOREntryOid+".2.1", &Value{valueType: ValueOid, oidVal: snmpMIBModuleOid}, SnmpModeRead)
+	interp.AddStaticValue(sysOREntryOid+".3.1", &Value{valueType: ValueString, stringVal: "The MIB module for SNMP entities"}, SnmpModeRead)
+	interp.AddStaticValue(sysOREntryOid+".4.1", &Value{valueType: ValueTimeticks}, SnmpModeRead)
+
+	return nil
+}
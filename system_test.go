@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func ExampleSystem1() {
+	prog := `
+var
+  desc: 2.1.1.1.0 string
+endvar
+run
+  desc = "Toshiba 2555c"
+endrun`
+	interp := runTestProgram(prog)
+	sysInits := VariableInits{"sysName": "printer1", "sysObjectID": ".1.3.6.1.4.1.1129.2.3.45.1"}
+	err := interp.AddSystemGroup(time.Now().Add(-time.Hour), sysInits)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	for _, oidStr := range sortedOids(interp.OidValues()) {
+		val, _ := interp.GetValueForOid(oidStr)
+		if oidStr == sysUpTimeOid {
+			// about an hour in ticks
+			fmt.Printf("%s: %d\n", oidStr, val.intVal/100/60)
+			continue
+		}
+		fmt.Printf("%s: %v %v\n", oidStr, val, interp.staticModes[oidStr])
+	}
+
+	err = interp.AddSystemGroup(time.Now(), VariableInits{"sysUptime": "1"})
+	fmt.Println(err)
+	// Output:
+	// .1.3.6.1.2.1.1.1.0: <String: Toshiba 2555c> 0
+	// .1.3.6.1.2.1.1.2.0: <OID: .1.3.6.1.4.1.1129.2.3.45.1> 0
+	// .1.3.6.1.2.1.1.3.0: 60
+	// .1.3.6.1.2.1.1.4.0: <String: > 1
+	// .1.3.6.1.2.1.1.5.0: <String: printer1> 1
+	// .1.3.6.1.2.1.1.6.0: <String: > 1
+	// .1.3.6.1.2.1.1.7.0: <Integer: 72> 0
+	// .1.3.6.1.2.1.1.8.0: <Timeticks: 0> 0
+	// .1.3.6.1.2.1.1.9.1.2.1: <OID: .1.3.6.1.6.3.1> 0
+	// .1.3.6.1.2.1.1.9.1.3.1: <String: The MIB module for SNMP entities> 0
+	// .1.3.6.1.2.1.1.9.1.4.1: <Timeticks: 0> 0
+	// Unknown system variable: sysUptime
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/PromonLogicalis/asn1"
+	"github.com/PromonLogicalis/snmp"
+)
+
+// Load the output of net-snmp "snmpwalk -On" into values keyed by OID
+// so that a device dump can be served as a read-only base MIB,
+// and write the live MIB back out in the same format.
+//
+// e.g.
+// .1.3.6.1.2.1.1.1.0 = STRING: "Toshiba 2555c"
+// .1.3.6.1.2.1.25.3.5.1.1.1 = INTEGER: idle(3)
+// .1.3.6.1.2.1.25.3.5.1.2.1 = Hex-STRING: 20 10
+// .1.3.6.1.2.1.25.1.1.0 = Timeticks: (21851051) 2 days, 12:41:50.51
+
+func loadWalkFile(filename string) (map[string]*Value, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vals, err := parseWalk(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return vals, nil
+}
+
+func parseWalk(r io.Reader) (map[string]*Value, error) {
+	vals := make(map[string]*Value)
+
+	// values such as long strings can continue over multiple lines
+	// so gather up each oid's text before converting it
+	var oidStr, text string
+	var lineNum, oidLineNum int
+	flush := func() error {
+		if oidStr == "" {
+			return nil
+		}
+		val, err := walkTextToValue(text)
+		if err != nil {
+			return fmt.Errorf("Error at line %d: %v", oidLineNum, err)
+		}
+		if val != nil {
+			vals[oidStr] = val
+		}
+		oidStr = ""
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		lineNum++
+
+		fields := strings.SplitN(line, " = ", 2)
+		if len(fields) == 2 && isValidOID(strings.TrimPrefix(fields[0], ".")) == nil {
+			err := flush()
+			if err != nil {
+				return nil, err
+			}
+			oidStr = fields[0]
+			if !strings.HasPrefix(oidStr, ".") {
+				oidStr = "." + oidStr
+			}
+			text = fields[1]
+			oidLineNum = lineNum
+		} else if oidStr != "" {
+			text += "\n" + line
+		} else if strings.TrimSpace(line) != "" {
+			return nil, fmt.Errorf("Error at line %d: expecting numeric OID (use snmpwalk -On)", lineNum)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	err := flush()
+	if err != nil {
+		return nil, err
+	}
+	return vals, nil
+}
+
+// Convert the text after the "=" of a walk line into a value
+// Returns nil value for types we don't serve e.g. "No more variables left"
+func walkTextToValue(text string) (val *Value, err error) {
+	val = new(Value)
+
+	if text == `""` {
+		// empty strings are shown without a type
+		val.valueType = ValueString
+		return val, nil
+	}
+
+	fields := strings.SplitN(text, ":", 2)
+	if len(fields) != 2 {
+		return nil, nil
+	}
+	typeStr := fields[0]
+	valStr := strings.TrimSpace(fields[1])
+
+	switch typeStr {
+	case "STRING":
+		val.valueType = ValueString
+		val.stringVal = valStr
+		if strings.HasPrefix(valStr, `"`) {
+			val.stringVal, err = strconv.Unquote(valStr)
+			if err != nil {
+				// not go quoting - just remove the quotes
+				val.stringVal = strings.TrimSuffix(strings.TrimPrefix(valStr, `"`), `"`)
+			}
+		}
+	case "Hex-STRING":
+		val.valueType = ValueString
+		bytes, err := hex.DecodeString(strings.Join(strings.Fields(valStr), ""))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Hex-STRING: %v", err)
+		}
+		val.stringVal = string(bytes)
+	case "INTEGER":
+		val.valueType = ValueInteger
+		// enumerations are shown as: name(3)
+		if i := strings.Index(valStr, "("); i >= 0 && strings.HasSuffix(valStr, ")") {
+			valStr = valStr[i+1 : len(valStr)-1]
+		}
+		val.intVal, err = walkInt(valStr)
+	case "Counter32":
+		val.valueType = ValueCounter
+		val.intVal, err = walkInt(valStr)
+	case "Gauge32":
+		val.valueType = ValueGuage
+		val.intVal, err = walkInt(valStr)
+	case "Timeticks":
+		// e.g. (21851051) 2 days, 12:41:50.51
+		val.valueType = ValueTimeticks
+		if i := strings.Index(valStr, ")"); strings.HasPrefix(valStr, "(") && i > 0 {
+			valStr = valStr[1:i]
+		}
+		val.intVal, err = walkInt(valStr)
+	case "OID":
+		val.valueType = ValueOid
+		val.oidVal = valStr
+		err = isValidOID(strings.TrimPrefix(valStr, "."))
+	case "IpAddress":
+		val.valueType = ValueIpv4address
+		val.addrVal = valStr
+		err = isValidIpv4Address(valStr)
+	default:
+		// unsupported type
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s value: %v", typeStr, err)
+	}
+	return val, nil
+}
+
+// integers can be followed by units e.g. "Gauge32: 100 percent"
+func walkInt(str string) (int, error) {
+	fields := strings.Fields(str)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("Missing number")
+	}
+	return strconv.Atoi(fields[0])
+}
+
+// Write every registered OID with its current value in "snmpwalk -On" format
+// The values are encoded under the lock so the snapshot is consistent.
+// Values which can't be encoded, like an unassigned OID, are left out.
+func writeWalk(w io.Writer, interp *Interpreter) error {
+	var buf strings.Builder
+
+	interp.valLock.RLock()
+	for _, oidStr := range sortedOids(interp.oid2Values) {
+		val, _ := interp.currentValue(oidStr)
+		snmpValue, err := valueToSnmp(interp, oidStr, val)
+		if err != nil {
+			logSkipped("dump", oidStr, err)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s = %s\n", oidStr, walkText(snmpValue))
+	}
+	interp.valLock.RUnlock()
+
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// logSkipped logs a value left out of a dump or export
+func logSkipped(action string, oidStr string, err error) {
+	if logger != nil {
+		logger.Printf("Unable to %s %s: %v\n", action, oidStr, err)
+	}
+}
+
+func writeWalkFile(filename string, interp *Interpreter) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = writeWalk(f, interp)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Format an agent value the way net-snmp shows it
+func walkText(snmpValue interface{}) string {
+	switch v := snmpValue.(type) {
+	case int:
+		return fmt.Sprintf("INTEGER: %d", v)
+	case string:
+		if len(v) == 0 {
+			return `""`
+		}
+		if !isPrintable(v) {
+			return "Hex-STRING: " + walkHex(v)
+		}
+		return fmt.Sprintf("STRING: %q", v)
+	case asn1.Oid:
+		return "OID: " + v.String()
+	case snmp.IPAddress:
+		return "IpAddress: " + v.String()
+	case snmp.Counter32:
+		return fmt.Sprintf("Counter32: %d", v)
+	case snmp.Unsigned32:
+		return fmt.Sprintf("Gauge32: %d", v)
+	case snmp.TimeTicks:
+		return fmt.Sprintf("Timeticks: (%d) %s", v, walkTicks(uint32(v)))
+	}
+	return fmt.Sprintf("Opaque: %v", snmpValue)
+}
+
+// e.g. 2 days, 12:41:50.51
+func walkTicks(ticks uint32) string {
+	centis := ticks % 100
+	secs := ticks / 100
+	days := secs / 86400
+	str := fmt.Sprintf("%d:%02d:%02d.%02d", secs/3600%24, secs/60%60, secs%60, centis)
+	switch days {
+	case 0:
+		return str
+	case 1:
+		return "1 day, " + str
+	}
+	return fmt.Sprintf("%d days, %s", days, str)
+}
+
+// e.g. 20 10 with 16 bytes per line
+func walkHex(str string) string {
+	hexStr := ""
+	for i := 0; i < len(str); i++ {
+		switch {
+		case i == 0:
+		case i%16 == 0:
+			hexStr += "\n"
+		default:
+			hexStr += " "
+		}
+		hexStr += fmt.Sprintf("%02X", str[i])
+	}
+	return hexStr
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// run a program and return its interpreter to examine the values
+func runTestProgram(progStr string) *Interpreter {
+	interp, program, err := initTestProgram(progStr)
+	if err != nil {
+		fmt.Printf("Parsing error: %s\n", err)
+		os.Exit(1)
+	}
+	err = interp.InterpProgram(program)
+	if err != nil {
+		fmt.Printf("Interpreting error: %s\n", err)
+		os.Exit(1)
+	}
+	return interp
+}
+
+func printStaticValues(vals map[string]*Value) {
+	var oids []string
+	for oidStr := range vals {
+		oids = append(oids, oidStr)
+	}
+	sort.Strings(oids)
+	for _, oidStr := range oids {
+		fmt.Printf("%s: %v\n", oidStr, vals[oidStr])
+	}
+}
+
+func ExampleWalk1() {
+	dump := `.1.3.6.1.2.1.1.1.0 = STRING: "Toshiba 2555c"
+.1.3.6.1.2.1.1.2.0 = OID: .1.3.6.1.4.1.1129.2.3.45.1
+.1.3.6.1.2.1.1.4.0 = ""
+.1.3.6.1.2.1.25.1.1.0 = Timeticks: (21851051) 2 days, 12:41:50.51
+.1.3.6.1.2.1.25.3.5.1.1.1 = INTEGER: idle(3)
+.1.3.6.1.2.1.25.3.5.1.2.1 = Hex-STRING: 41 42 43 44 45 46 47 48 49 4A 4B 4C 4D 4E 4F 50 
+51 52 
+.1.3.6.1.2.1.43.10.2.1.4.1.1 = Counter32: 1042
+.1.3.6.1.2.1.4.20.1.1.10.100.63.22 = IpAddress: 10.100.63.22
+.1.3.6.1.2.1.4.20.1.1.10.100.63.22 = IpAddress: 10.100.63.22
+.1.3.6.1.2.1.43.11.1.1.9.1.1 = Gauge32: 42 percent
+.1.3.6.1.2.1.99.1.0 = Counter64: 12345678901
+.1.3.6.1.2.1.99.2.0 = No more variables left in this MIB View (It is past the end of the MIB tree)
+`
+	vals, err := parseWalk(strings.NewReader(dump))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printStaticValues(vals)
+	// Output:
+	// .1.3.6.1.2.1.1.1.0: <String: Toshiba 2555c>
+	// .1.3.6.1.2.1.1.2.0: <OID: .1.3.6.1.4.1.1129.2.3.45.1>
+	// .1.3.6.1.2.1.1.4.0: <String: >
+	// .1.3.6.1.2.1.25.1.1.0: <Timeticks: 21851051>
+	// .1.3.6.1.2.1.25.3.5.1.1.1: <Integer: 3>
+	// .1.3.6.1.2.1.25.3.5.1.2.1: <String: ABCDEFGHIJKLMNOPQR>
+	// .1.3.6.1.2.1.4.20.1.1.10.100.63.22: <Ipaddress: 10.100.63.22>
+	// .1.3.6.1.2.1.43.10.2.1.4.1.1: <Counter: 1042>
+	// .1.3.6.1.2.1.43.11.1.1.9.1.1: <Guage: 42>
+}
+
+func ExampleWalk2() {
+	prog := `
+var
+  desc: 2.1.1.1.0 string
+  ticks: 2.1.1.3.0 timeticks
+  errors: 2.1.25.3.5.1.2.1 bitset [0 = 'low paper', 2 = 'low toner']
+  pages: 2.1.43.10.2.1.4.1.1 counter
+  status: 2.1.25.3.5.1.1.1 integer
+  object: 2.1.1.2.0 oid
+  host: 2.1.4.20.1.1.10.100.63.22 ipaddress
+  level: 2.1.43.11.1.1.9.1.1 guage
+  contact: 2.1.1.4.0 string
+endvar
+run
+  desc = "Toshiba 2555c"
+  ticks = 21851051
+  errors = ['low paper', 'low toner']
+  pages = 1042
+  status = 3
+  object = .1.3.6.1.4.1.1129
+  host = 10.100.63.22
+  level = 42
+endrun`
+	interp := runTestProgram(prog)
+	var buf strings.Builder
+	err := writeWalk(&buf, interp)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Print(buf.String())
+
+	// and read it back in
+	vals, err := parseWalk(strings.NewReader(buf.String()))
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Println(len(vals), "values")
+	// Output:
+	// .1.3.6.1.2.1.1.1.0 = STRING: "Toshiba 2555c"
+	// .1.3.6.1.2.1.1.2.0 = OID: .1.3.6.1.4.1.1129
+	// .1.3.6.1.2.1.1.3.0 = Timeticks: (21851051) 2 days, 12:41:50.51
+	// .1.3.6.1.2.1.1.4.0 = ""
+	// .1.3.6.1.2.1.4.20.1.1.10.100.63.22 = IpAddress: 10.100.63.22
+	// .1.3.6.1.2.1.25.3.5.1.1.1 = INTEGER: 3
+	// .1.3.6.1.2.1.25.3.5.1.2.1 = Hex-STRING: A0
+	// .1.3.6.1.2.1.43.10.2.1.4.1.1 = Counter32: 1042
+	// .1.3.6.1.2.1.43.11.1.1.9.1.1 = Gauge32: 42
+	// 9 values
+}
+
+func ExampleWalk3() {
+	// unassigned OIDs and addresses are left out of the dump
+	interp := runTestProgram(`
+var
+  desc: 2.1.1.1.0 string
+  object: 2.1.1.2.0 oid
+  host: 2.1.4.20.1.1.10.100.63.22 ipaddress
+endvar
+run
+  desc = "Toshiba 2555c"
+endrun`)
+	var buf strings.Builder
+	err := writeWalk(&buf, interp)
+	if err != nil {
+		fmt.Println(err)
+	}
+	fmt.Print(buf.String())
+	// Output:
+	// .1.3.6.1.2.1.1.1.0 = STRING: "Toshiba 2555c"
+}